@@ -1,13 +1,22 @@
 package ptest
 
 import (
-	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// Stat represents aggregated statistics for a time period
+// DefaultPercentiles are the percentiles computed when none are configured
+var DefaultPercentiles = []float64{50, 90, 95, 99, 99.9}
+
+// Stat represents aggregated statistics for a time period.
+// Response times are in milliseconds with sub-millisecond precision and
+// TPS is per second regardless of the period's length.
 type Stat struct {
 	Time                  int64   `json:"Time"`
+	TimeMs                int64   `json:"TimeMs"`
+	IntervalMs            int64   `json:"IntervalMs"`
 	TpsSuccess            float64 `json:"TpsSuccess"`
 	TpsFailure            float64 `json:"TpsFailure"`
 	ResponseTime          float64 `json:"ResponseTime"`
@@ -21,18 +30,110 @@ type Stat struct {
 	ErrorRate             float64 `json:"ErrorRate"`
 	SuccessCount          int     `json:"SuccessCount"`
 	FailureCount          int     `json:"FailureCount"`
+
+	ResponseTimeMin           float64 `json:"ResponseTimeMin"`
+	ResponseTimeMax           float64 `json:"ResponseTimeMax"`
+	ResponseTimeStdDev        float64 `json:"ResponseTimeStdDev"`
+	FailureResponseTimeMin    float64 `json:"FailureResponseTimeMin"`
+	FailureResponseTimeMax    float64 `json:"FailureResponseTimeMax"`
+	FailureResponseTimeStdDev float64 `json:"FailureResponseTimeStdDev"`
+
+	// InFlight is the number of requests between Begin and End at the end of
+	// the period and PeakInFlight the most at any moment during it
+	InFlight     int64 `json:"InFlight"`
+	PeakInFlight int64 `json:"PeakInFlight"`
+	// AvgConcurrency is the average number of requests in flight by Little's
+	// law: total response time divided by the length of the period
+	AvgConcurrency float64 `json:"AvgConcurrency"`
+	// QueueTime and QueueTime99 are the mean and 99th percentile queue time
+	// in milliseconds of requests that were queued
+	QueueTime   float64 `json:"QueueTime"`
+	QueueTime99 float64 `json:"QueueTime99"`
+
+	// ScheduledIterations counts the open-model executor's arrivals and
+	// MissedIterations those that found every worker busy. TargetRate is the
+	// configured arrival rate averaged over the interval and AchievedRate the
+	// started arrivals per second.
+	ScheduledIterations int64   `json:"ScheduledIterations"`
+	MissedIterations    int64   `json:"MissedIterations"`
+	TargetRate          float64 `json:"TargetRate"`
+	AchievedRate        float64 `json:"AchievedRate"`
+
+	// Phases holds the mean HTTP phase timings of traced requests
+	Phases *PhaseStat `json:"Phases,omitempty"`
+	// Responses holds the status codes and sizes of requests with a response
+	Responses *ResponseStat `json:"Responses,omitempty"`
+
+	// Metrics holds the custom metrics recorded during the period
+	Metrics map[string]*MetricStat `json:"Metrics,omitempty"`
+	// Runtime holds the load generator's health if runtime sampling is on
+	Runtime *RuntimeStat `json:"Runtime,omitempty"`
+	// Corrected holds the latency fields corrected for coordinated omission,
+	// set if any trip of the period had an intended start time
+	Corrected *Stat `json:"Corrected,omitempty"`
+
+	// Percentiles and FailurePercentiles hold the configured percentiles,
+	// keyed by percentileKey, e.g. "p99.9"
+	Percentiles        map[string]float64 `json:"Percentiles,omitempty"`
+	FailurePercentiles map[string]float64 `json:"FailurePercentiles,omitempty"`
+
+	// ErrorClasses counts failures by error class
+	ErrorClasses map[string]int `json:"ErrorClasses,omitempty"`
+	// ErrorSamples holds a few error messages per error class
+	ErrorSamples map[string][]string `json:"-"`
+
+	// SuccessHistogram and FailureHistogram hold the latency distributions
+	// the percentiles were computed from, so periods can be merged exactly
+	SuccessHistogram *Histogram `json:"-"`
+	FailureHistogram *Histogram `json:"-"`
+	QueueHistogram   *Histogram `json:"-"`
+	// sampleEvery is how many trips every value in the histograms stands for
+	sampleEvery int64
+
+	// Labels is set on per-label stats
+	Labels map[string]string `json:"-"`
+	// Labeled holds the same period broken down by label set
+	Labeled map[string]*Stat `json:"-"`
+}
+
+// withoutHistograms returns a copy of the stat that does not keep the
+// histograms or the per-label stats alive, for storing in long-lived buffers
+func (s *Stat) withoutHistograms() *Stat {
+	copied := *s
+	copied.SuccessHistogram = nil
+	copied.FailureHistogram = nil
+	copied.QueueHistogram = nil
+	// Label sets have their own chart series
+	copied.Labeled = nil
+	copied.Metrics = metricsWithoutHistograms(s.Metrics)
+	copied.Runtime = s.Runtime.withoutHistogram()
+	if s.Corrected != nil {
+		copied.Corrected = s.Corrected.withoutHistograms()
+	}
+	return &copied
 }
 
 // DataAggregator processes TripsOfSec and generates statistics
 type DataAggregator struct {
 	currentStat *Stat
+	percentiles []float64
 	mutex       sync.RWMutex
+
+	// backpressure decides what happens to stats that do not fit the output
+	backpressure BackpressurePolicy
+	// droppedStats and droppedStatTrips count the stats dropped because the
+	// output channel was full and the trips they held
+	droppedStats     int64
+	droppedStatTrips int64
 }
 
-// newDataAggregator creates a new data aggregator
-func newDataAggregator() *DataAggregator {
+// newDataAggregator creates a new data aggregator computing the given
+// percentiles; under BackpressureBlock it waits for room in its output
+func newDataAggregator(percentiles []float64, backpressure BackpressurePolicy) *DataAggregator {
 	return &DataAggregator{
-		mutex: sync.RWMutex{},
+		percentiles:  percentiles,
+		backpressure: backpressure,
+		mutex:        sync.RWMutex{},
 	}
 }
 
@@ -47,15 +148,27 @@ func (da *DataAggregator) Process(inputChan chan *TripsOfSec, outputChan chan *S
 		da.currentStat = stat
 		da.mutex.Unlock()
 
+		if da.backpressure == BackpressureBlock {
+			outputChan <- stat
+			continue
+		}
+
 		select {
 		case outputChan <- stat:
 			// Successfully sent
 		default:
-			// Channel full, skip this stat
+			// Channel full, skip this stat and count it
+			atomic.AddInt64(&da.droppedStats, 1)
+			atomic.AddInt64(&da.droppedStatTrips, int64(stat.SuccessCount+stat.FailureCount))
 		}
 	}
 }
 
+// dropped returns how many stats were dropped and how many trips they held
+func (da *DataAggregator) dropped() (int64, int64) {
+	return atomic.LoadInt64(&da.droppedStats), atomic.LoadInt64(&da.droppedStatTrips)
+}
+
 // GetCurrentStat returns the current stat
 func (da *DataAggregator) GetCurrentStat() *Stat {
 	da.mutex.RLock()
@@ -65,77 +178,143 @@ func (da *DataAggregator) GetCurrentStat() *Stat {
 
 // calculateStat calculates statistics from TripsOfSec
 func (da *DataAggregator) calculateStat(trips *TripsOfSec) *Stat {
+	// Sampled buckets stand for more trips than they hold
+	scale := 1
+	if trips.SampleEvery > 1 {
+		scale = int(trips.SampleEvery)
+	}
+
+	successCount := int(trips.Success.Count())
+	failureCount := int(trips.Failures.Count())
+
+	interval := trips.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
 	stat := &Stat{
-		Time:         trips.Time,
-		SuccessCount: len(trips.Success),
-		FailureCount: len(trips.Failures),
+		Time:             trips.Time,
+		TimeMs:           trips.Start.UnixMilli(),
+		IntervalMs:       interval.Milliseconds(),
+		SuccessCount:     successCount * scale,
+		FailureCount:     failureCount * scale,
+		ErrorClasses:     trips.ErrorClasses,
+		ErrorSamples:     trips.ErrorSamples,
+		InFlight:         trips.InFlight,
+		PeakInFlight:     trips.PeakInFlight,
+		SuccessHistogram: trips.Success,
+		FailureHistogram: trips.Failures,
+		QueueHistogram:   trips.Queue,
+		sampleEvery:      int64(scale),
+		Phases:           phaseStat(trips.phases),
+		Responses:        responseStat(trips.responses, int64(scale)),
+		Labels:           trips.Labels,
+		Metrics:          calculateMetrics(trips.metrics, interval),
+		Runtime:          trips.Runtime,
+		Corrected:        da.correctedStat(trips),
+	}
+
+	if scale > 1 && len(trips.ErrorClasses) > 0 {
+		stat.ErrorClasses = make(map[string]int, len(trips.ErrorClasses))
+		for class, count := range trips.ErrorClasses {
+			stat.ErrorClasses[class] = count * scale
+		}
 	}
 
 	// Calculate TPS
-	stat.TpsSuccess = float64(len(trips.Success))
-	stat.TpsFailure = float64(len(trips.Failures))
+	stat.TpsSuccess = float64(stat.SuccessCount) / interval.Seconds()
+	stat.TpsFailure = float64(stat.FailureCount) / interval.Seconds()
+
+	// Open-model arrival rates
+	stat.ScheduledIterations = trips.Scheduled
+	stat.MissedIterations = trips.Missed
+	stat.TargetRate = trips.Target / interval.Seconds()
+	stat.AchievedRate = float64(trips.Scheduled-trips.Missed) / interval.Seconds()
 
 	// Calculate error rate
-	totalCount := len(trips.Success) + len(trips.Failures)
+	totalCount := successCount + failureCount
 	if totalCount > 0 {
-		stat.ErrorRate = float64(len(trips.Failures)) / float64(totalCount) * 100
+		stat.ErrorRate = float64(failureCount) / float64(totalCount) * 100
 	}
 
-	// Calculate response time statistics for successful requests
-	if len(trips.Success) > 0 {
-		stat.ResponseTime = calculateMean(trips.Success)
-		stat.ResponseTime90 = calculatePercentile(trips.Success, 90)
-		stat.ResponseTime95 = calculatePercentile(trips.Success, 95)
-		stat.ResponseTime99 = calculatePercentile(trips.Success, 99)
-	}
+	// Calculate response time statistics for successful and failed requests
+	setLatencyStats(stat, trips.Success, trips.Failures, da.percentiles)
+	setQueueStats(stat, trips.Queue)
+
+	// Little's law: requests in flight = arrival rate * response time
+	busy := totalDuration(trips.Success) + totalDuration(trips.Failures)
+	stat.AvgConcurrency = busy / float64(interval) * float64(scale)
 
-	// Calculate response time statistics for failed requests
-	if len(trips.Failures) > 0 {
-		stat.FailureResponseTime = calculateMean(trips.Failures)
-		stat.FailureResponseTime90 = calculatePercentile(trips.Failures, 90)
-		stat.FailureResponseTime95 = calculatePercentile(trips.Failures, 95)
-		stat.FailureResponseTime99 = calculatePercentile(trips.Failures, 99)
+	// Calculate per-label statistics
+	if len(trips.Labeled) > 0 {
+		stat.Labeled = make(map[string]*Stat, len(trips.Labeled))
+		for key, labeled := range trips.Labeled {
+			stat.Labeled[key] = da.calculateStat(labeled)
+		}
 	}
 
 	return stat
 }
 
-// calculateMean calculates the mean of a slice of integers
-func calculateMean(values []int) float64 {
-	if len(values) == 0 {
-		return 0
+// durationToMillis converts a duration to fractional milliseconds
+func durationToMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// percentileKey returns the key of a percentile in Stat.Percentiles, e.g. "p99.9"
+func percentileKey(percentile float64) string {
+	return "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+// percentileMap computes the given percentiles of a histogram in milliseconds
+func percentileMap(histogram *Histogram, percentiles []float64) map[string]float64 {
+	if len(percentiles) == 0 {
+		return nil
 	}
 
-	sum := 0
-	for _, v := range values {
-		sum += v
+	result := make(map[string]float64, len(percentiles))
+	for _, percentile := range percentiles {
+		result[percentileKey(percentile)] = durationToMillis(histogram.Percentile(percentile))
 	}
-	return float64(sum) / float64(len(values))
+	return result
 }
 
-// calculatePercentile calculates the specified percentile of a slice of integers
-func calculatePercentile(values []int, percentile float64) float64 {
-	if len(values) == 0 {
-		return 0
+// setLatencyStats fills the response time fields of a stat from its latency histograms
+func setLatencyStats(stat *Stat, success, failure *Histogram, percentiles []float64) {
+	if success != nil && success.Count() > 0 {
+		stat.ResponseTime = durationToMillis(success.Mean())
+		stat.ResponseTime90 = durationToMillis(success.Percentile(90))
+		stat.ResponseTime95 = durationToMillis(success.Percentile(95))
+		stat.ResponseTime99 = durationToMillis(success.Percentile(99))
+		stat.ResponseTimeMin = durationToMillis(success.Min())
+		stat.ResponseTimeMax = durationToMillis(success.Max())
+		stat.ResponseTimeStdDev = durationToMillis(success.StdDev())
+		stat.Percentiles = percentileMap(success, percentiles)
 	}
 
-	// Create a copy and sort it
-	sorted := make([]int, len(values))
-	copy(sorted, values)
-	sort.Ints(sorted)
+	if failure != nil && failure.Count() > 0 {
+		stat.FailureResponseTime = durationToMillis(failure.Mean())
+		stat.FailureResponseTime90 = durationToMillis(failure.Percentile(90))
+		stat.FailureResponseTime95 = durationToMillis(failure.Percentile(95))
+		stat.FailureResponseTime99 = durationToMillis(failure.Percentile(99))
+		stat.FailureResponseTimeMin = durationToMillis(failure.Min())
+		stat.FailureResponseTimeMax = durationToMillis(failure.Max())
+		stat.FailureResponseTimeStdDev = durationToMillis(failure.StdDev())
+		stat.FailurePercentiles = percentileMap(failure, percentiles)
+	}
+}
 
-	// Calculate percentile index
-	index := (percentile / 100.0) * float64(len(sorted)-1)
+// totalDuration returns the sum of all durations recorded in a histogram in nanoseconds
+func totalDuration(histogram *Histogram) float64 {
+	return float64(histogram.Mean()) * float64(histogram.Count())
+}
 
-	// Handle exact index
-	if index == float64(int(index)) {
-		return float64(sorted[int(index)])
+// setQueueStats fills the queue time fields of a stat from its queue time histogram
+func setQueueStats(stat *Stat, queue *Histogram) {
+	if queue == nil || queue.Count() == 0 {
+		return
 	}
 
-	// Interpolate between two values
-	lower := int(index)
-	upper := lower + 1
-	weight := index - float64(lower)
-
-	return float64(sorted[lower])*(1-weight) + float64(sorted[upper])*weight
+	stat.QueueTime = durationToMillis(queue.Mean())
+	stat.QueueTime99 = durationToMillis(queue.Percentile(99))
 }
@@ -0,0 +1,63 @@
+package ptest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregatorBackpressure(t *testing.T) {
+	const intervals = 5
+
+	tests := []struct {
+		policy       BackpressurePolicy
+		wantReceived int
+		wantDropped  int64
+	}{
+		{BackpressureDrop, 1, intervals - 1},
+		{BackpressureSample, 1, intervals - 1},
+		{BackpressureBlock, intervals, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			da := newDataAggregator(DefaultPercentiles, tt.policy)
+			input := make(chan *TripsOfSec, intervals)
+			output := make(chan *Stat, 1)
+
+			start := time.Unix(1000, 0)
+			for i := 0; i < intervals; i++ {
+				trips := newTripsOfSec(start.Add(time.Duration(i)*time.Second), time.Second, nil, DefaultHistogramPrecision)
+				trips.Success.Record(time.Millisecond)
+				trips.Success.Record(time.Millisecond)
+				input <- trips
+			}
+			close(input)
+
+			done := make(chan struct{})
+			go func() {
+				da.Process(input, output)
+				close(done)
+			}()
+
+			// Read only once the aggregator has finished or blocked, so
+			// the non-blocking policies find the output full
+			select {
+			case <-done:
+			case <-time.After(100 * time.Millisecond):
+			}
+
+			received := 0
+			for range output {
+				received++
+			}
+
+			stats, trips := da.dropped()
+			if received != tt.wantReceived {
+				t.Errorf("received %d stats, want %d", received, tt.wantReceived)
+			}
+			if stats != tt.wantDropped || trips != 2*tt.wantDropped {
+				t.Errorf("dropped %d stats with %d trips, want %d with %d", stats, trips, tt.wantDropped, 2*tt.wantDropped)
+			}
+		})
+	}
+}
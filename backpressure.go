@@ -0,0 +1,70 @@
+package ptest
+
+import "math"
+
+// BackpressurePolicy decides what the collector does when its pipeline is full
+type BackpressurePolicy string
+
+const (
+	// BackpressureDrop drops aggregated intervals that do not fit and counts them
+	BackpressureDrop BackpressurePolicy = "drop"
+	// BackpressureBlock makes the collector and the aggregator wait until the
+	// next stage has room
+	BackpressureBlock BackpressurePolicy = "block"
+	// BackpressureSample records only a fraction of trips and scales counts up;
+	// anything that still does not fit is dropped and counted
+	BackpressureSample BackpressurePolicy = "sample"
+)
+
+// CollectorConfig configures how a session's data collector handles load
+type CollectorConfig struct {
+	Backpressure BackpressurePolicy
+	// SampleRate is the fraction of trips recorded by BackpressureSample, in (0, 1]
+	SampleRate float64
+	// Precision is the number of significant decimal digits (1 to 5) kept by
+	// latency histograms; higher precision uses more memory per interval
+	Precision int
+	// SampleRuntime records the load generator's goroutines, memory, CPU
+	// usage and GC pauses every interval
+	SampleRuntime bool
+}
+
+// DefaultCollectorConfig returns the collector configuration used when none is set
+func DefaultCollectorConfig() CollectorConfig {
+	return CollectorConfig{
+		Backpressure: BackpressureDrop,
+		SampleRate:   1,
+		Precision:    DefaultHistogramPrecision,
+	}
+}
+
+// sampleEvery returns how many trips are seen for each recorded one
+func (c CollectorConfig) sampleEvery() int64 {
+	if c.Backpressure != BackpressureSample || c.SampleRate <= 0 || c.SampleRate >= 1 {
+		return 1
+	}
+	return int64(math.Round(1 / c.SampleRate))
+}
+
+// DropStats counts data lost to backpressure
+type DropStats struct {
+	Policy BackpressurePolicy `json:"policy"`
+	// SampleRate is the fraction of trips actually recorded
+	SampleRate float64 `json:"sample_rate"`
+	// DroppedIntervals counts intervals dropped because the collector's or
+	// the aggregator's output channel was full
+	DroppedIntervals int64 `json:"dropped_intervals"`
+	// DroppedIntervalTrips counts the trips inside the dropped intervals
+	DroppedIntervalTrips int64 `json:"dropped_interval_trips"`
+	// DroppedLabelTrips counts labeled trips that got no per-label statistics
+	// because the label set limit was reached; they are in the overall statistics
+	DroppedLabelTrips int64 `json:"dropped_label_trips"`
+	// DroppedMetricValues counts custom metric values dropped because the
+	// metric limit was reached or the name was used with another kind
+	DroppedMetricValues int64 `json:"dropped_metric_values"`
+}
+
+// HasDrops reports whether any data was lost
+func (d *DropStats) HasDrops() bool {
+	return d.DroppedIntervals > 0 || d.DroppedMetricValues > 0
+}
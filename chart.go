@@ -1,48 +1,115 @@
 package ptest
 
 import (
+	"sort"
 	"sync"
+	"time"
 )
 
+// ChartTier describes one resolution of the chart data
+type ChartTier struct {
+	Name string
+	// Interval is the width of one point, a multiple of the session interval
+	Interval time.Duration
+	// Points is how many points the tier keeps
+	Points int
+}
+
+// DefaultChartTiers returns the tier ladder for a base interval: 300 points
+// at the base interval, 360 at 5x and 480 at 30x, i.e. 5 minutes, 30 minutes
+// and 4 hours for one-second intervals
+func DefaultChartTiers(interval time.Duration) []ChartTier {
+	return []ChartTier{
+		{Name: "recent", Interval: interval, Points: 300},
+		{Name: "medium", Interval: interval * 5, Points: 360},
+		{Name: "longterm", Interval: interval * 30, Points: 480},
+	}
+}
+
 // ChartData represents optimized chart data with different resolutions
 type ChartData struct {
-	Recent   []*Stat `json:"recent"`   // Last 5 minutes, 1-second resolution
-	Medium   []*Stat `json:"medium"`   // Last 30 minutes, 5-second resolution
-	LongTerm []*Stat `json:"longterm"` // Full duration, 30-second resolution
+	// Tiers holds the data of each tier, finest resolution first
+	Tiers []*TierData `json:"tiers"`
+
+	// Recent, Medium and LongTerm repeat the stats of the first three tiers
+	// under the keys used before the tiers were configurable.
+	//
+	// Deprecated: use Tiers.
+	Recent   []*Stat `json:"recent"`
+	Medium   []*Stat `json:"medium"`
+	LongTerm []*Stat `json:"longterm"`
+	// IntervalMs is the session's base interval
+	IntervalMs int64 `json:"interval_ms"`
+
+	// Percentiles lists the percentiles present in every stat
+	Percentiles []float64 `json:"percentiles"`
+}
+
+// TierData holds the points of one chart tier
+type TierData struct {
+	Name       string  `json:"name"`
+	IntervalMs int64   `json:"interval_ms"`
+	Stats      []*Stat `json:"stats"`
+}
+
+// chartTier keeps the points of one tier and the stats of its current window
+type chartTier struct {
+	ChartTier
+	buffer      *CircularBuffer
+	accumulator []*Stat
+	windowStart int64
 }
 
 // ChartDataManager manages chart data with automatic optimization
 type ChartDataManager struct {
-	recentBuffer   *CircularBuffer
-	mediumBuffer   *CircularBuffer
-	longTermBuffer *CircularBuffer
+	interval time.Duration
+	tiers    []*chartTier
 
-	maxRecentPoints   int
-	maxMediumPoints   int
-	maxLongTermPoints int
+	// labeled holds a chart data manager per label set
+	labeled map[string]*ChartDataManager
 
-	lastMediumTime   int64
-	lastLongTermTime int64
+	percentiles []float64
 
-	mediumAccumulator   []*Stat
-	longTermAccumulator []*Stat
+	// version counts the data points added
+	version uint64
 
 	mutex sync.RWMutex
 }
 
-// newChartDataManager creates a new chart data manager
-func newChartDataManager() *ChartDataManager {
-	return &ChartDataManager{
-		recentBuffer:        newCircularBuffer(300), // 5 minutes
-		mediumBuffer:        newCircularBuffer(360), // 30 minutes / 5 seconds
-		longTermBuffer:      newCircularBuffer(480), // 4 hours / 30 seconds
-		maxRecentPoints:     300,
-		maxMediumPoints:     360,
-		maxLongTermPoints:   480,
-		mediumAccumulator:   make([]*Stat, 0, 5),
-		longTermAccumulator: make([]*Stat, 0, 30),
-		mutex:               sync.RWMutex{},
+// newChartDataManager creates a new chart data manager for stats of the given
+// interval, keeping the given tiers and computing the given percentiles
+func newChartDataManager(interval time.Duration, tiers []ChartTier, percentiles []float64) *ChartDataManager {
+	if len(tiers) == 0 {
+		tiers = DefaultChartTiers(interval)
+	}
+
+	cdm := &ChartDataManager{
+		interval:    interval,
+		tiers:       make([]*chartTier, 0, len(tiers)),
+		labeled:     make(map[string]*ChartDataManager),
+		percentiles: percentiles,
+		mutex:       sync.RWMutex{},
 	}
+
+	for _, tier := range tiers {
+		// Windows hold whole intervals
+		if tier.Interval < interval {
+			tier.Interval = interval
+		}
+		if remainder := tier.Interval % interval; remainder != 0 {
+			tier.Interval += interval - remainder
+		}
+		if tier.Points <= 0 {
+			tier.Points = 300
+		}
+		cdm.tiers = append(cdm.tiers, &chartTier{
+			ChartTier:   tier,
+			buffer:      newCircularBuffer(tier.Points),
+			accumulator: make([]*Stat, 0, int(tier.Interval/interval)),
+		})
+	}
+
+	return cdm
 }
 
 // AddDataPoint adds a new data point and handles compression
@@ -50,30 +117,46 @@ func (cdm *ChartDataManager) AddDataPoint(stat *Stat) {
 	cdm.mutex.Lock()
 	defer cdm.mutex.Unlock()
 
-	// Always add to recent buffer
-	cdm.recentBuffer.Add(stat)
-
-	// Handle medium-term aggregation (5-second intervals)
-	cdm.mediumAccumulator = append(cdm.mediumAccumulator, stat)
-	if stat.Time >= cdm.lastMediumTime+5 || len(cdm.mediumAccumulator) >= 5 {
-		if len(cdm.mediumAccumulator) > 0 {
-			aggregated := cdm.aggregateStats(cdm.mediumAccumulator)
-			cdm.mediumBuffer.Add(aggregated)
-			cdm.lastMediumTime = stat.Time
-			cdm.mediumAccumulator = cdm.mediumAccumulator[:0]
+	cdm.version++
+
+	for _, tier := range cdm.tiers {
+		// Tiers at the base resolution store stats as they are; only the
+		// accumulators need the histograms
+		if tier.Interval == cdm.interval {
+			tier.buffer.Add(stat.withoutHistograms())
+			continue
+		}
+
+		// Aggregate once a stat falls into the next window of the tier
+		tierMs := tier.Interval.Milliseconds()
+		window := stat.TimeMs - stat.TimeMs%tierMs
+		if len(tier.accumulator) > 0 && window != tier.windowStart {
+			tier.buffer.Add(cdm.aggregateStats(tier.accumulator, tier.windowStart, tierMs))
+			tier.accumulator = tier.accumulator[:0]
 		}
+
+		tier.windowStart = window
+		tier.accumulator = append(tier.accumulator, stat)
 	}
 
-	// Handle long-term aggregation (30-second intervals)
-	cdm.longTermAccumulator = append(cdm.longTermAccumulator, stat)
-	if stat.Time >= cdm.lastLongTermTime+30 || len(cdm.longTermAccumulator) >= 30 {
-		if len(cdm.longTermAccumulator) > 0 {
-			aggregated := cdm.aggregateStats(cdm.longTermAccumulator)
-			cdm.longTermBuffer.Add(aggregated)
-			cdm.lastLongTermTime = stat.Time
-			cdm.longTermAccumulator = cdm.longTermAccumulator[:0]
+	// Feed per-label series
+	for key, labeled := range stat.Labeled {
+		manager, ok := cdm.labeled[key]
+		if !ok {
+			manager = cdm.newLike()
+			cdm.labeled[key] = manager
 		}
+		manager.AddDataPoint(labeled)
+	}
+}
+
+// newLike creates an empty chart data manager with the same configuration
+func (cdm *ChartDataManager) newLike() *ChartDataManager {
+	tiers := make([]ChartTier, len(cdm.tiers))
+	for i, tier := range cdm.tiers {
+		tiers[i] = tier.ChartTier
 	}
+	return newChartDataManager(cdm.interval, tiers, cdm.percentiles)
 }
 
 // GetOptimizedData returns optimized chart data
@@ -81,65 +164,142 @@ func (cdm *ChartDataManager) GetOptimizedData() *ChartData {
 	cdm.mutex.RLock()
 	defer cdm.mutex.RUnlock()
 
-	return &ChartData{
-		Recent:   cdm.recentBuffer.GetAll(),
-		Medium:   cdm.mediumBuffer.GetAll(),
-		LongTerm: cdm.longTermBuffer.GetAll(),
+	data := &ChartData{
+		Tiers:       make([]*TierData, len(cdm.tiers)),
+		IntervalMs:  cdm.interval.Milliseconds(),
+		Percentiles: cdm.percentiles,
+	}
+
+	for i, tier := range cdm.tiers {
+		data.Tiers[i] = &TierData{
+			Name:       tier.Name,
+			IntervalMs: tier.Interval.Milliseconds(),
+			Stats:      tier.buffer.GetAll(),
+		}
 	}
+
+	legacy := []*[]*Stat{&data.Recent, &data.Medium, &data.LongTerm}
+	for i := 0; i < len(legacy) && i < len(data.Tiers); i++ {
+		*legacy[i] = data.Tiers[i].Stats
+	}
+
+	return data
 }
 
-// aggregateStats aggregates multiple stats into one with proper weighted averaging
-func (cdm *ChartDataManager) aggregateStats(stats []*Stat) *Stat {
-	if len(stats) == 0 {
+// dataVersion returns a number that changes whenever a data point is added
+func (cdm *ChartDataManager) dataVersion() uint64 {
+	cdm.mutex.RLock()
+	defer cdm.mutex.RUnlock()
+	return cdm.version
+}
+
+// tierIntervals returns the point width of every tier in milliseconds
+func (cdm *ChartDataManager) tierIntervals() []int64 {
+	intervals := make([]int64, len(cdm.tiers))
+	for i, tier := range cdm.tiers {
+		intervals[i] = tier.Interval.Milliseconds()
+	}
+	return intervals
+}
+
+// labeledData returns optimized chart data for one label set, or nil if it
+// has none
+func (cdm *ChartDataManager) labeledData(key string) *ChartData {
+	cdm.mutex.RLock()
+	manager, ok := cdm.labeled[key]
+	cdm.mutex.RUnlock()
+
+	if !ok {
 		return nil
 	}
-	if len(stats) == 1 {
-		return stats[0]
+	return manager.GetOptimizedData()
+}
+
+// GetLabels returns the keys of all label sets with chart data
+func (cdm *ChartDataManager) GetLabels() []string {
+	cdm.mutex.RLock()
+	defer cdm.mutex.RUnlock()
+
+	keys := make([]string, 0, len(cdm.labeled))
+	for key := range cdm.labeled {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// GetLabeledData returns optimized chart data for one label set
+func (cdm *ChartDataManager) GetLabeledData(key string) *ChartData {
+	if data := cdm.labeledData(key); data != nil {
+		return data
+	}
+	return cdm.newLike().GetOptimizedData()
+}
+
+// aggregateStats aggregates the stats of one tier window into one. Response
+// times come from the merged latency histograms of all stats.
+func (cdm *ChartDataManager) aggregateStats(stats []*Stat, windowStart, windowMs int64) *Stat {
+	if len(stats) == 0 {
+		return nil
 	}
 
 	aggregated := &Stat{
-		Time: stats[len(stats)-1].Time, // Use the latest time
+		Time:       windowStart / 1000,
+		TimeMs:     windowStart,
+		IntervalMs: windowMs,
 	}
 
 	var totalSuccess, totalFailure int
-	var successRTSum, failureRTSum float64
-	var successCount, failureCount int
+	var scheduled, missed int64
 
-	// Response time data for percentiles (collect raw values if available)
-	var allSuccessRTs, allFailureRTs []float64
+	// Merged latency distributions of the whole period
+	var successHistogram, failureHistogram, queueHistogram *Histogram
+	var busyMs, target float64
 
 	for _, stat := range stats {
-		aggregated.TpsSuccess += stat.TpsSuccess
-		aggregated.TpsFailure += stat.TpsFailure
 		totalSuccess += stat.SuccessCount
 		totalFailure += stat.FailureCount
 
-		// Weighted sum for response times
-		if stat.SuccessCount > 0 && stat.ResponseTime > 0 {
-			successRTSum += stat.ResponseTime * float64(stat.SuccessCount)
-			successCount += stat.SuccessCount
-		}
-		if stat.FailureCount > 0 && stat.FailureResponseTime > 0 {
-			failureRTSum += stat.FailureResponseTime * float64(stat.FailureCount)
-			failureCount += stat.FailureCount
+		for class, count := range stat.ErrorClasses {
+			if aggregated.ErrorClasses == nil {
+				aggregated.ErrorClasses = make(map[string]int)
+			}
+			aggregated.ErrorClasses[class] += count
 		}
 
-		// For percentiles, we approximate using existing percentile values
-		// This is not perfect but better than simple averaging
-		if stat.SuccessCount > 0 {
-			allSuccessRTs = append(allSuccessRTs,
-				stat.ResponseTime, stat.ResponseTime90,
-				stat.ResponseTime95, stat.ResponseTime99)
-		}
-		if stat.FailureCount > 0 {
-			allFailureRTs = append(allFailureRTs,
-				stat.FailureResponseTime, stat.FailureResponseTime90,
-				stat.FailureResponseTime95, stat.FailureResponseTime99)
+		successHistogram = mergeHistogram(successHistogram, stat.SuccessHistogram)
+		failureHistogram = mergeHistogram(failureHistogram, stat.FailureHistogram)
+		queueHistogram = mergeHistogram(queueHistogram, stat.QueueHistogram)
+
+		busyMs += stat.AvgConcurrency * float64(stat.IntervalMs)
+		target += stat.TargetRate * float64(stat.IntervalMs) / 1000
+		scheduled += stat.ScheduledIterations
+		missed += stat.MissedIterations
+		if stat.PeakInFlight > aggregated.PeakInFlight {
+			aggregated.PeakInFlight = stat.PeakInFlight
 		}
 	}
 
 	aggregated.SuccessCount = totalSuccess
 	aggregated.FailureCount = totalFailure
+	aggregated.ScheduledIterations = scheduled
+	aggregated.MissedIterations = missed
+
+	// Normalize TPS to per second over the time the stats cover
+	first, last := stats[0], stats[len(stats)-1]
+	if covered := float64(last.TimeMs+last.IntervalMs-first.TimeMs) / 1000; covered > 0 {
+		aggregated.TpsSuccess = float64(totalSuccess) / covered
+		aggregated.TpsFailure = float64(totalFailure) / covered
+		aggregated.AvgConcurrency = busyMs / 1000 / covered
+		aggregated.TargetRate = target / covered
+		aggregated.AchievedRate = float64(scheduled-missed) / covered
+		aggregated.Metrics = aggregateMetrics(stats, covered)
+	}
+	aggregated.InFlight = last.InFlight
+	aggregated.Runtime = aggregateRuntime(stats)
+	aggregated.Phases = aggregatePhases(stats)
+	aggregated.Responses = aggregateResponses(stats)
+	aggregated.Corrected = cdm.aggregateCorrected(stats)
 
 	// Calculate error rate
 	total := totalSuccess + totalFailure
@@ -147,30 +307,32 @@ func (cdm *ChartDataManager) aggregateStats(stats []*Stat) *Stat {
 		aggregated.ErrorRate = float64(totalFailure) / float64(total) * 100
 	}
 
-	// Calculate weighted average response times
-	if successCount > 0 {
-		aggregated.ResponseTime = successRTSum / float64(successCount)
-		// Approximate percentiles from available data
-		if len(allSuccessRTs) > 0 {
-			aggregated.ResponseTime90 = calculatePercentileFloat(allSuccessRTs, 90)
-			aggregated.ResponseTime95 = calculatePercentileFloat(allSuccessRTs, 95)
-			aggregated.ResponseTime99 = calculatePercentileFloat(allSuccessRTs, 99)
-		}
-	}
-
-	if failureCount > 0 {
-		aggregated.FailureResponseTime = failureRTSum / float64(failureCount)
-		// Approximate percentiles from available data
-		if len(allFailureRTs) > 0 {
-			aggregated.FailureResponseTime90 = calculatePercentileFloat(allFailureRTs, 90)
-			aggregated.FailureResponseTime95 = calculatePercentileFloat(allFailureRTs, 95)
-			aggregated.FailureResponseTime99 = calculatePercentileFloat(allFailureRTs, 99)
-		}
-	}
+	// Calculate response times from the merged distributions
+	setLatencyStats(aggregated, successHistogram, failureHistogram, cdm.percentiles)
+	setQueueStats(aggregated, queueHistogram)
 
 	return aggregated
 }
 
+// mergeHistogram merges src into dst, creating dst on first use so the
+// per-second histograms are never modified
+func mergeHistogram(dst, src *Histogram) *Histogram {
+	return mergeHistogramWeighted(dst, src, 1)
+}
+
+// mergeHistogramWeighted is mergeHistogram with every value of src counted
+// weight times
+func mergeHistogramWeighted(dst, src *Histogram, weight int64) *Histogram {
+	if src == nil || src.Count() == 0 {
+		return dst
+	}
+	if dst == nil {
+		dst = src.newEmptyLike()
+	}
+	dst.mergeWeighted(src, weight)
+	return dst
+}
+
 // CircularBuffer is a circular buffer for stats
 type CircularBuffer struct {
 	data     []*Stat
@@ -218,53 +380,3 @@ func (cb *CircularBuffer) GetAll() []*Stat {
 
 	return result
 }
-
-// Helper functions for float64 slices
-func calculateMeanFloat(values []float64) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-
-	sum := 0.0
-	for _, v := range values {
-		sum += v
-	}
-	return sum / float64(len(values))
-}
-
-func calculatePercentileFloat(values []float64, percentile float64) float64 {
-	if len(values) == 0 {
-		return 0
-	}
-
-	// Sort values for proper percentile calculation
-	sorted := make([]float64, len(values))
-	copy(sorted, values)
-
-	// Simple bubble sort (sufficient for small slices)
-	for i := 0; i < len(sorted); i++ {
-		for j := i + 1; j < len(sorted); j++ {
-			if sorted[i] > sorted[j] {
-				sorted[i], sorted[j] = sorted[j], sorted[i]
-			}
-		}
-	}
-
-	// Calculate percentile index
-	index := (percentile / 100.0) * float64(len(sorted)-1)
-
-	// Handle exact index
-	if index == float64(int(index)) {
-		return sorted[int(index)]
-	}
-
-	// Interpolate between two values
-	lower := int(index)
-	upper := lower + 1
-	if upper >= len(sorted) {
-		return sorted[lower]
-	}
-
-	weight := index - float64(lower)
-	return sorted[lower]*(1-weight) + sorted[upper]*weight
-}
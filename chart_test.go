@@ -0,0 +1,142 @@
+package ptest
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// testStat calculates the stat of one interval holding the given latencies
+func testStat(start time.Time, interval time.Duration, latencies ...time.Duration) *Stat {
+	trips := newTripsOfSec(start, interval, nil, DefaultHistogramPrecision)
+	for _, latency := range latencies {
+		trips.Success.Record(latency)
+	}
+
+	labeled := newTripsOfSec(start, interval, map[string]string{OperationLabel: "search"}, DefaultHistogramPrecision)
+	labeled.Success.Merge(trips.Success)
+	trips.Labeled = map[string]*TripsOfSec{"operation=search": labeled}
+
+	return newDataAggregator(DefaultPercentiles, BackpressureDrop).calculateStat(trips)
+}
+
+func TestChartBuffersDropHistograms(t *testing.T) {
+	cdm := newChartDataManager(time.Second, nil, DefaultPercentiles)
+	start := time.Unix(1200, 0)
+	for i := 0; i < 60; i++ {
+		cdm.AddDataPoint(testStat(start.Add(time.Duration(i)*time.Second), time.Second, time.Millisecond))
+	}
+
+	for _, tier := range cdm.GetOptimizedData().Tiers {
+		for _, stat := range tier.Stats {
+			if stat.SuccessHistogram != nil || stat.FailureHistogram != nil || stat.QueueHistogram != nil {
+				t.Fatalf("tier %s keeps histograms", tier.Name)
+			}
+			if stat.Labeled != nil {
+				t.Fatalf("tier %s keeps per-label stats", tier.Name)
+			}
+		}
+	}
+
+	if data := cdm.GetLabeledData("operation=search"); len(data.Tiers[0].Stats) != 60 {
+		t.Errorf("labeled series has %d points, want 60", len(data.Tiers[0].Stats))
+	}
+}
+
+func TestChartTierIntervals(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		tiers    []time.Duration
+		want     []time.Duration
+	}{
+		{"multiples kept", time.Second, []time.Duration{time.Second, 5 * time.Second}, []time.Duration{time.Second, 5 * time.Second}},
+		{"finer than the interval", time.Second, []time.Duration{100 * time.Millisecond}, []time.Duration{time.Second}},
+		{"rounded up to a multiple", time.Second, []time.Duration{1500 * time.Millisecond}, []time.Duration{2 * time.Second}},
+		{"millisecond interval", time.Millisecond, []time.Duration{time.Millisecond, 5 * time.Millisecond}, []time.Duration{time.Millisecond, 5 * time.Millisecond}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers := make([]ChartTier, len(tt.tiers))
+			for i, interval := range tt.tiers {
+				tiers[i] = ChartTier{Name: "tier", Interval: interval, Points: 10}
+			}
+
+			cdm := newChartDataManager(tt.interval, tiers, DefaultPercentiles)
+			for i, tier := range cdm.tiers {
+				if tier.Interval != tt.want[i] {
+					t.Errorf("tier %d interval %v, want %v", i, tier.Interval, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChartTierAggregation(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		tier     time.Duration
+		points   int
+		want     int
+	}{
+		{"seconds", time.Second, 5 * time.Second, 20, 3},
+		{"milliseconds", time.Millisecond, 5 * time.Millisecond, 20, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiers := []ChartTier{
+				{Name: "base", Interval: tt.interval, Points: 100},
+				{Name: "coarse", Interval: tt.tier, Points: 100},
+			}
+			cdm := newChartDataManager(tt.interval, tiers, DefaultPercentiles)
+
+			start := time.UnixMilli(1_000_000)
+			for i := 0; i < tt.points; i++ {
+				cdm.AddDataPoint(testStat(start.Add(time.Duration(i)*tt.interval), tt.interval, time.Millisecond, time.Millisecond))
+			}
+
+			data := cdm.GetOptimizedData()
+			coarse := data.Tiers[1].Stats
+			// The last window is still accumulating
+			if len(coarse) != tt.want {
+				t.Fatalf("coarse tier has %d points, want %d", len(coarse), tt.want)
+			}
+			for _, stat := range coarse {
+				if stat.IntervalMs != tt.tier.Milliseconds() || stat.SuccessCount != 2*int(tt.tier/tt.interval) {
+					t.Errorf("point at %d covers %dms with %d trips", stat.TimeMs, stat.IntervalMs, stat.SuccessCount)
+				}
+				if stat.TimeMs%tt.tier.Milliseconds() != 0 {
+					t.Errorf("point at %d is not aligned to %v", stat.TimeMs, tt.tier)
+				}
+			}
+		})
+	}
+}
+
+func TestChartDataLegacyKeys(t *testing.T) {
+	cdm := newChartDataManager(time.Second, nil, DefaultPercentiles)
+	cdm.AddDataPoint(testStat(time.Unix(1200, 0), time.Second, time.Millisecond))
+
+	encoded, err := json.Marshal(cdm.GetOptimizedData())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"tiers", "recent", "medium", "longterm"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("chart data lacks %q", key)
+		}
+	}
+
+	var recent []*Stat
+	if err := json.Unmarshal(decoded["recent"], &recent); err != nil || len(recent) != 1 {
+		t.Errorf("recent holds %d stats (%v), want 1", len(recent), err)
+	}
+}
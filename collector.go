@@ -1,123 +1,663 @@
 package ptest
 
 import (
+	"log"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 )
 
+// bucketGrace is how long after its end a bucket stays open for trips that arrive late
+const bucketGrace = time.Second
+
+// DefaultInterval is the width of the aggregation buckets when none is configured
+const DefaultInterval = time.Second
+
+// shardsPerProc is how many collector shards are created per GOMAXPROCS
+const shardsPerProc = 4
+
 // Trip represents a single test result
 type Trip struct {
 	StartTime time.Time
+	EndTime   time.Time
 	Success   bool
+	Labels    map[string]string
+
+	// ErrorClass and ErrorMessage describe why a failed trip failed
+	ErrorClass   string
+	ErrorMessage string
+
+	// QueueTime is how long the trip waited before it was sent; it is part
+	// of the response time
+	QueueTime time.Duration
+
+	// IntendedStartTime is when a paced workload meant to send the trip; if
+	// set, the trip's latency is also recorded corrected for coordinated omission
+	IntendedStartTime time.Time
+
+	// Phases holds the phase timings of an HTTP request, if traced
+	Phases *HTTPPhases
+
+	// StatusCode and ResponseSize describe the response to the request, such
+	// as an HTTP status code and the body size in bytes. They are recorded
+	// only if StatusCode is set.
+	StatusCode   int
+	ResponseSize int64
+
+	// labeledOnly keeps the trip out of the overall statistics, e.g. a whole
+	// transaction whose steps are counted already
+	labeledOnly bool
 }
 
-// TripsOfSec contains all trips within one second
+// Duration returns the response time of the trip
+func (t *Trip) Duration() time.Duration {
+	return t.EndTime.Sub(t.StartTime)
+}
+
+// TripsOfSec contains the response time distributions of all trips within one
+// aggregation interval, one second unless configured otherwise
 type TripsOfSec struct {
+	// Time is the start of the interval in Unix seconds
 	Time     int64
-	Success  []int
-	Failures []int
+	Start    time.Time
+	Interval time.Duration
+	Success  *Histogram
+	Failures *Histogram
+
+	// CorrectedSuccess and CorrectedFailures hold latencies measured from
+	// the intended start; nil until a trip with an intended start is added
+	CorrectedSuccess  *Histogram
+	CorrectedFailures *Histogram
+
+	// SampleEvery is how many trips each recorded trip stands for
+	SampleEvery int64
+
+	// Queue holds the queue times of trips that had one; nil if none did
+	Queue *Histogram
+	// phases adds up the HTTP phases of trips that had them; nil if none did
+	phases *phaseSums
+	// responses counts the status codes and sizes of trips that had a
+	// response; nil if none did
+	responses *responseSums
+	// InFlight is the number of requests in flight at the end of the
+	// interval and PeakInFlight the most at any moment during it
+	InFlight     int64
+	PeakInFlight int64
+
+	// Scheduled counts the arrivals of the open-model executor and Missed
+	// those that found every worker busy. Target is the number of arrivals
+	// the configured rate asked for during the interval, whether the
+	// scheduler kept up or not.
+	Scheduled int64
+	Missed    int64
+	Target    float64
+
+	// ErrorClasses counts failures by error class
+	ErrorClasses map[string]int
+	// ErrorSamples holds a few error messages per error class
+	ErrorSamples map[string][]string
+
+	// Labels is set on per-label buckets
+	Labels map[string]string
+	// Labeled holds the same interval broken down by label set
+	Labeled map[string]*TripsOfSec
+
+	// metrics holds the custom metrics recorded during the interval
+	metrics map[string]*metricBucket
+
+	// Runtime holds the load generator's health if runtime sampling is on
+	Runtime *RuntimeStat
+}
+
+// newTripsOfSec creates an empty bucket for an interval
+func newTripsOfSec(start time.Time, interval time.Duration, labels map[string]string, precision int) *TripsOfSec {
+	return &TripsOfSec{
+		Time:     start.Unix(),
+		Start:    start,
+		Interval: interval,
+		Success:  NewHistogram(precision),
+		Failures: NewHistogram(precision),
+		Labels:   labels,
+	}
+}
+
+// count returns the number of trips in the bucket
+func (t *TripsOfSec) count() int64 {
+	return t.Success.Count() + t.Failures.Count()
 }
 
-// DataCollector collects raw test data and aggregates by second
+// add adds the response time of a trip to the bucket
+func (t *TripsOfSec) add(responseTime time.Duration, trip *Trip) {
+	if trip.QueueTime > 0 {
+		if t.Queue == nil {
+			t.Queue = t.Success.newEmptyLike()
+		}
+		t.Queue.Record(trip.QueueTime)
+	}
+
+	if trip.Phases != nil {
+		if t.phases == nil {
+			t.phases = &phaseSums{}
+		}
+		t.phases.add(trip.Phases)
+	}
+
+	if trip.StatusCode != 0 {
+		if t.responses == nil {
+			t.responses = &responseSums{}
+		}
+		t.responses.add(trip)
+	}
+
+	if !trip.IntendedStartTime.IsZero() && t.CorrectedSuccess == nil {
+		t.startCorrecting()
+	}
+
+	if trip.Success {
+		t.Success.Record(responseTime)
+		if t.CorrectedSuccess != nil {
+			t.CorrectedSuccess.Record(trip.CorrectedDuration())
+		}
+		return
+	}
+
+	t.Failures.Record(responseTime)
+	if t.CorrectedFailures != nil {
+		t.CorrectedFailures.Record(trip.CorrectedDuration())
+	}
+
+	class := trip.ErrorClass
+	if class == "" {
+		class = ErrorClassUnclassified
+	}
+	if t.ErrorClasses == nil {
+		t.ErrorClasses = make(map[string]int)
+	}
+	t.ErrorClasses[class]++
+
+	if trip.ErrorMessage != "" && len(t.ErrorSamples[class]) < maxErrorSamples {
+		if t.ErrorSamples == nil {
+			t.ErrorSamples = make(map[string][]string)
+		}
+		t.ErrorSamples[class] = append(t.ErrorSamples[class], trip.ErrorMessage)
+	}
+}
+
+// merge adds all trips of another bucket for the same interval
+func (t *TripsOfSec) merge(other *TripsOfSec) {
+	t.mergeCorrected(other)
+	t.Scheduled += other.Scheduled
+	t.Missed += other.Missed
+	t.Target += other.Target
+	t.Success.Merge(other.Success)
+	t.Failures.Merge(other.Failures)
+	t.Queue = mergeHistogram(t.Queue, other.Queue)
+	t.phases = mergePhases(t.phases, other.phases)
+	t.responses = mergeResponses(t.responses, other.responses)
+
+	for class, count := range other.ErrorClasses {
+		if t.ErrorClasses == nil {
+			t.ErrorClasses = make(map[string]int)
+		}
+		t.ErrorClasses[class] += count
+	}
+
+	for class, samples := range other.ErrorSamples {
+		if t.ErrorSamples == nil {
+			t.ErrorSamples = make(map[string][]string)
+		}
+		room := maxErrorSamples - len(t.ErrorSamples[class])
+		if room > len(samples) {
+			room = len(samples)
+		}
+		if room > 0 {
+			t.ErrorSamples[class] = append(t.ErrorSamples[class], samples[:room]...)
+		}
+	}
+
+	for name, m := range other.metrics {
+		if t.metrics == nil {
+			t.metrics = make(map[string]*metricBucket)
+		}
+		if existing, ok := t.metrics[name]; ok {
+			existing.merge(m)
+		} else {
+			t.metrics[name] = m
+		}
+	}
+
+	for key, labeled := range other.Labeled {
+		if t.Labeled == nil {
+			t.Labeled = make(map[string]*TripsOfSec)
+		}
+		if existing, ok := t.Labeled[key]; ok {
+			existing.merge(labeled)
+		} else {
+			t.Labeled[key] = labeled
+		}
+	}
+}
+
+// collectorShard holds the open intervals recorded by a subset of reporters
+type collectorShard struct {
+	mutex     sync.Mutex
+	buckets   map[int64]*TripsOfSec
+	sampleSeq int64
+
+	// total is updated atomically so it can be read without the lock
+	total int64
+}
+
+// DataCollector collects raw test data and aggregates by interval.
+// It is not lock-free: every report holds the mutex of one shard while it
+// updates the shard's bucket. Reports pick a random shard out of several per
+// CPU, so concurrent reporters rarely wait for each other; the shards are
+// merged into one TripsOfSec per interval at each interval boundary.
 type DataCollector struct {
-	tripChan   chan *Trip
 	ResultChan chan *TripsOfSec
-	totalReqs  int64
-	isRunning  bool
+	classifier ErrorClassifier
+
+	shards    []*collectorShard
+	shardMask uint64
+	running   atomic.Bool
+	done      chan struct{}
+
+	// interval is the bucket width; buckets are numbered by Unix time / interval
+	interval time.Duration
+	// publishedUntil is the last bucket merged and sent to ResultChan
+	publishedUntil int64
+	// nextIndex is the next bucket to publish; only run touches it
+	nextIndex int64
+
+	// inFlight counts requests between Begin and End and peakInFlight is
+	// the most since the last interval boundary
+	inFlight     int64
+	peakInFlight int64
+
+	// missedIterations counts the open-model arrivals that found every worker busy
+	missedIterations int64
+	// concurrency holds the samples of intervals not published yet; only
+	// run touches it
+	concurrency     map[int64]concurrencySample
+	lastConcurrency concurrencySample
+
+	// runtime samples the load generator's health if enabled; runtimeStats
+	// holds the samples of intervals not published yet. Only run touches them.
+	runtime      *runtimeSampler
+	runtimeStats map[int64]*RuntimeStat
+
+	config      CollectorConfig
+	sampleEvery int64
+
+	droppedIntervals     int64
+	droppedIntervalTrips int64
+
+	labelSets  map[string]bool
+	labelMutex sync.Mutex
+	// droppedLabelTrips counts trips whose label set was over the limit
+	droppedLabelTrips int64
+	labelWarning      sync.Once
+
+	metricKinds map[string]MetricKind
+	metricMutex sync.Mutex
+	// droppedMetricValues counts the values of metrics that were not kept
+	droppedMetricValues int64
+	metricWarning       sync.Once
+	// lastGauges holds the last value of every gauge; only run touches it
+	lastGauges map[string]float64
 }
 
-// newDataCollector creates a new data collector
-func newDataCollector() *DataCollector {
+// newDataCollector creates a new data collector with the given bucket width
+func newDataCollector(config CollectorConfig, interval time.Duration) *DataCollector {
+	if config.Backpressure == "" {
+		config.Backpressure = BackpressureDrop
+	}
+	if config.Precision == 0 {
+		config.Precision = DefaultHistogramPrecision
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	shardCount := 1
+	for shardCount < runtime.GOMAXPROCS(0)*shardsPerProc {
+		shardCount <<= 1
+	}
+
 	dc := &DataCollector{
-		tripChan:   make(chan *Trip, 2048),
-		ResultChan: make(chan *TripsOfSec, 1024),
-		isRunning:  true,
+		ResultChan:  make(chan *TripsOfSec, 1024),
+		classifier:  DefaultErrorClassifier,
+		shards:      make([]*collectorShard, shardCount),
+		shardMask:   uint64(shardCount - 1),
+		done:        make(chan struct{}),
+		interval:    interval,
+		config:      config,
+		sampleEvery: config.sampleEvery(),
+		labelSets:   make(map[string]bool),
+		concurrency: make(map[int64]concurrencySample),
+		metricKinds: make(map[string]MetricKind),
+		lastGauges:  make(map[string]float64),
+	}
+
+	if config.SampleRuntime {
+		dc.runtime = newRuntimeSampler(config.Precision)
+		dc.runtimeStats = make(map[int64]*RuntimeStat)
+	}
+
+	for i := range dc.shards {
+		dc.shards[i] = &collectorShard{
+			buckets: make(map[int64]*TripsOfSec),
+		}
 	}
 
-	go dc.consume()
+	// Intervals are published from the one the collector starts in, so
+	// earlier trips are counted in the first interval
+	dc.nextIndex = time.Now().UnixNano() / int64(interval)
+	dc.publishedUntil = dc.nextIndex - 1
+
+	dc.running.Store(true)
+	go dc.run()
 	return dc
 }
 
-// Report reports a single test result
+// Report reports a single test result that finished now
 func (dc *DataCollector) Report(start time.Time, success bool) {
-	if !dc.isRunning {
-		return
-	}
+	dc.record(&Trip{
+		StartTime: start,
+		EndTime:   time.Now(),
+		Success:   success,
+	})
+}
 
-	atomic.AddInt64(&dc.totalReqs, 1)
+// ReportDuration reports a single test result with an explicitly measured response time
+func (dc *DataCollector) ReportDuration(start time.Time, elapsed time.Duration, success bool) {
+	dc.record(&Trip{
+		StartTime: start,
+		EndTime:   start.Add(elapsed),
+		Success:   success,
+	})
+}
 
-	trip := &Trip{
+// ReportWithLabels reports a single test result that finished now, labeled for per-label statistics
+func (dc *DataCollector) ReportWithLabels(start time.Time, success bool, labels map[string]string) {
+	dc.record(&Trip{
 		StartTime: start,
+		EndTime:   time.Now(),
 		Success:   success,
+		Labels:    labels,
+	})
+}
+
+// ReportError reports a single test result that finished now; a nil error is a success
+func (dc *DataCollector) ReportError(start time.Time, err error) {
+	trip := Trip{
+		StartTime: start,
+		EndTime:   time.Now(),
+		Success:   err == nil,
 	}
+	dc.setError(&trip, err)
+	dc.record(&trip)
+}
 
-	select {
-	case dc.tripChan <- trip:
-		// Successfully sent
-	default:
-		// Channel full, drop the data (or implement backpressure)
+// setError classifies an error and records it on a trip
+func (dc *DataCollector) setError(trip *Trip, err error) {
+	if err == nil {
+		return
+	}
+
+	trip.ErrorClass = dc.classifier(err)
+	trip.ErrorMessage = err.Error()
+}
+
+// ReportTrip reports a single test result with its own start and end time.
+// A trip without an end time finished now.
+func (dc *DataCollector) ReportTrip(trip *Trip) {
+	if trip == nil {
+		return
+	}
+
+	if trip.EndTime.IsZero() {
+		ended := *trip
+		ended.EndTime = time.Now()
+		trip = &ended
+	}
+
+	dc.record(trip)
+}
+
+// record adds a trip to a shard. The trip is not retained, so callers may
+// pass a pointer to a stack value.
+func (dc *DataCollector) record(trip *Trip) {
+	if !dc.running.Load() {
+		return
+	}
+
+	shard := dc.shards[rand.Uint64()&dc.shardMask]
+	if !trip.labeledOnly {
+		atomic.AddInt64(&shard.total, 1)
+	}
+
+	responseTime := trip.Duration()
+	index := trip.EndTime.UnixNano() / int64(dc.interval)
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if dc.sampleEvery > 1 {
+		shard.sampleSeq++
+		if shard.sampleSeq%dc.sampleEvery != 0 {
+			return
+		}
+	}
+
+	bucket := dc.openBucket(shard, index)
+
+	// Add response time to appropriate bucket
+	if !trip.labeledOnly {
+		bucket.add(responseTime, trip)
+	}
+
+	if labeled := dc.labeledBucket(bucket, trip.Labels); labeled != nil {
+		labeled.add(responseTime, trip)
+	}
+}
+
+// openBucket returns a shard's bucket for an interval, creating it on first
+// use. The shard's lock must be held.
+func (dc *DataCollector) openBucket(shard *collectorShard, index int64) *TripsOfSec {
+	// Data for an already published interval goes to the oldest open one
+	if published := atomic.LoadInt64(&dc.publishedUntil); index <= published {
+		index = published + 1
+	}
+
+	bucket, ok := shard.buckets[index]
+	if !ok {
+		bucket = newTripsOfSec(time.Unix(0, index*int64(dc.interval)), dc.interval, nil, dc.config.Precision)
+		bucket.SampleEvery = dc.sampleEvery
+		shard.buckets[index] = bucket
 	}
+	return bucket
 }
 
-// Stop stops the data collector
+// Stop stops the data collector; remaining intervals are published before
+// ResultChan is closed
 func (dc *DataCollector) Stop() {
-	dc.isRunning = false
-	close(dc.tripChan)
+	if dc.running.CompareAndSwap(true, false) {
+		close(dc.done)
+	}
 }
 
 // GetTotalRequests returns total number of requests processed
 func (dc *DataCollector) GetTotalRequests() int64 {
-	return atomic.LoadInt64(&dc.totalReqs)
+	var total int64
+	for _, shard := range dc.shards {
+		total += atomic.LoadInt64(&shard.total)
+	}
+	return total
 }
 
-// consume processes raw trips and groups them by second
-func (dc *DataCollector) consume() {
-	defer close(dc.ResultChan)
+// GetDropStats returns how much data was lost to backpressure
+func (dc *DataCollector) GetDropStats() *DropStats {
+	return &DropStats{
+		Policy:               dc.config.Backpressure,
+		SampleRate:           1 / float64(dc.sampleEvery),
+		DroppedIntervals:     atomic.LoadInt64(&dc.droppedIntervals),
+		DroppedIntervalTrips: atomic.LoadInt64(&dc.droppedIntervalTrips),
+		DroppedLabelTrips:    atomic.LoadInt64(&dc.droppedLabelTrips),
+		DroppedMetricValues:  atomic.LoadInt64(&dc.droppedMetricValues),
+	}
+}
 
-	var currentSec *TripsOfSec
+// run merges and publishes the shards at every interval boundary
+func (dc *DataCollector) run() {
+	defer close(dc.ResultChan)
 
-	for trip := range dc.tripChan {
+	for {
+		// Wake up right after the next interval boundary
 		now := time.Now()
-		responseTime := int(now.Sub(trip.StartTime).Milliseconds())
-		currentSecond := now.Unix()
-
-		// Check if we need to create new second bucket
-		if currentSec == nil || currentSec.Time != currentSecond {
-			// Publish previous second if it exists
-			if currentSec != nil {
-				dc.publish(currentSec)
-			}
+		timer := time.NewTimer(dc.nextBoundary(now).Sub(now))
+
+		select {
+		case tick := <-timer.C:
+			dc.sampleConcurrency(tick.UnixNano()/int64(dc.interval) - 1)
+			dc.sampleRuntime(tick.UnixNano()/int64(dc.interval) - 1)
 
-			// Create new second bucket
-			currentSec = &TripsOfSec{
-				Time:     currentSecond,
-				Success:  make([]int, 0),
-				Failures: make([]int, 0),
+			// Trips from concurrent reporters arrive slightly out of order, so
+			// intervals are kept open for a grace period after they end
+			dc.flush(tick.Add(-bucketGrace).UnixNano()/int64(dc.interval) - 1)
+		case <-dc.done:
+			timer.Stop()
+			// Publish remaining intervals, including the current partial one
+			dc.sampleConcurrency(time.Now().UnixNano() / int64(dc.interval))
+			dc.sampleRuntime(time.Now().UnixNano() / int64(dc.interval))
+			dc.flush(math.MaxInt64)
+			return
+		}
+	}
+}
+
+// nextBoundary returns the first interval boundary after t. Like bucket
+// indexes, boundaries are multiples of the interval since the Unix epoch.
+func (dc *DataCollector) nextBoundary(t time.Time) time.Time {
+	return time.Unix(0, (t.UnixNano()/int64(dc.interval)+1)*int64(dc.interval))
+}
+
+// labeledBucket returns the per-label bucket for a label set, or nil if the
+// trip is unlabeled or the label set limit has been reached
+func (dc *DataCollector) labeledBucket(bucket *TripsOfSec, labels map[string]string) *TripsOfSec {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	key := labelKey(labels)
+	labeled, ok := bucket.Labeled[key]
+	if ok {
+		return labeled
+	}
+
+	dc.labelMutex.Lock()
+	if !dc.labelSets[key] {
+		if len(dc.labelSets) >= maxLabelSets {
+			dc.labelMutex.Unlock()
+			dc.dropLabelSet(key)
+			return nil
+		}
+		dc.labelSets[key] = true
+	}
+	dc.labelMutex.Unlock()
+
+	if bucket.Labeled == nil {
+		bucket.Labeled = make(map[string]*TripsOfSec)
+	}
+
+	labeled = newTripsOfSec(bucket.Start, bucket.Interval, copyLabels(labels), dc.config.Precision)
+	labeled.SampleEvery = bucket.SampleEvery
+	bucket.Labeled[key] = labeled
+	return labeled
+}
+
+// dropLabelSet counts a trip whose label set is over the limit, warning about the first one
+func (dc *DataCollector) dropLabelSet(key string) {
+	atomic.AddInt64(&dc.droppedLabelTrips, dc.sampleEvery)
+	dc.labelWarning.Do(func() {
+		log.Printf("ptest: more than %d label sets, reports labeled %s and other new label sets only count in the overall statistics", maxLabelSets, key)
+	})
+}
+
+// flush merges the shards' buckets up to and including index until and
+// publishes them in chronological order. Every elapsed interval is
+// published, with an empty TripsOfSec if nothing was reported in it, so
+// stalls show up as zero traffic instead of gaps.
+func (dc *DataCollector) flush(until int64) {
+	if until != math.MaxInt64 && until > atomic.LoadInt64(&dc.publishedUntil) {
+		atomic.StoreInt64(&dc.publishedUntil, until)
+	}
+
+	merged := make(map[int64]*TripsOfSec)
+	for _, shard := range dc.shards {
+		shard.mutex.Lock()
+		for index, bucket := range shard.buckets {
+			if index > until {
+				continue
+			}
+			if existing, ok := merged[index]; ok {
+				existing.merge(bucket)
+			} else {
+				merged[index] = bucket
 			}
+			delete(shard.buckets, index)
 		}
+		shard.mutex.Unlock()
+	}
 
-		// Add response time to appropriate bucket
-		if trip.Success {
-			currentSec.Success = append(currentSec.Success, responseTime)
-		} else {
-			currentSec.Failures = append(currentSec.Failures, responseTime)
+	// The final flush fills empty intervals up to the current one only;
+	// buckets of trips ending in the future are published as they are
+	last := until
+	if until == math.MaxInt64 {
+		last = time.Now().UnixNano() / int64(dc.interval)
+	}
+
+	for ; dc.nextIndex <= last; dc.nextIndex++ {
+		bucket, ok := merged[dc.nextIndex]
+		if !ok {
+			bucket = newTripsOfSec(time.Unix(0, dc.nextIndex*int64(dc.interval)), dc.interval, nil, dc.config.Precision)
+			bucket.SampleEvery = dc.sampleEvery
 		}
+		dc.setConcurrency(dc.nextIndex, bucket)
+		dc.carryGauges(bucket)
+		dc.setRuntime(dc.nextIndex, bucket)
+		delete(merged, dc.nextIndex)
+		dc.publish(bucket)
+	}
+
+	indexes := make([]int64, 0, len(merged))
+	for index := range merged {
+		indexes = append(indexes, index)
 	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
 
-	// Publish last second if exists
-	if currentSec != nil {
-		dc.publish(currentSec)
+	for _, index := range indexes {
+		dc.publish(merged[index])
 	}
 }
 
 // publish sends TripsOfSec to result channel
 func (dc *DataCollector) publish(trips *TripsOfSec) {
-	if len(trips.Success) > 0 || len(trips.Failures) > 0 {
-		select {
-		case dc.ResultChan <- trips:
-			// Successfully sent
-		default:
-			// Channel full, skip this data point
-		}
+	if dc.config.Backpressure == BackpressureBlock {
+		dc.ResultChan <- trips
+		return
+	}
+
+	select {
+	case dc.ResultChan <- trips:
+		// Successfully sent
+	default:
+		// Channel full, skip this data point and count it
+		atomic.AddInt64(&dc.droppedIntervals, 1)
+		atomic.AddInt64(&dc.droppedIntervalTrips, trips.count()*trips.SampleEvery)
 	}
 }
@@ -0,0 +1,233 @@
+package ptest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect stops a collector and merges every interval it published
+func collect(t *testing.T, dc *DataCollector) *TripsOfSec {
+	t.Helper()
+
+	dc.Stop()
+	merged := newTripsOfSec(time.Time{}, dc.interval, nil, dc.config.Precision)
+	for trips := range dc.ResultChan {
+		merged.merge(trips)
+	}
+	return merged
+}
+
+func TestReportTripEndTime(t *testing.T) {
+	start := time.Now().Add(-50 * time.Millisecond)
+
+	tests := []struct {
+		name     string
+		trip     Trip
+		min, max time.Duration
+	}{
+		{"explicit end", Trip{StartTime: start, EndTime: start.Add(20 * time.Millisecond), Success: true}, 20 * time.Millisecond, 20 * time.Millisecond},
+		{"zero end finishes now", Trip{StartTime: start, Success: true}, 50 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			end := tt.trip.EndTime
+			dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+			dc.ReportTrip(&tt.trip)
+			trips := collect(t, dc)
+
+			if got := trips.Success.Count(); got != 1 {
+				t.Fatalf("recorded %d trips, want 1", got)
+			}
+			if got := trips.Success.Max(); got < tt.min || got > tt.max {
+				t.Errorf("response time %v, want between %v and %v", got, tt.min, tt.max)
+			}
+			if tt.trip.EndTime != end {
+				t.Errorf("end time of the caller's trip changed to %v", tt.trip.EndTime)
+			}
+		})
+	}
+}
+
+func TestNextBoundary(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		now      time.Time
+		want     time.Time
+	}{
+		{"second", time.Second, time.Unix(100, 300), time.Unix(101, 0)},
+		{"on a boundary", time.Second, time.Unix(100, 0), time.Unix(101, 0)},
+		{"seven seconds from the epoch", 7 * time.Second, time.Unix(100, 0), time.Unix(105, 0)},
+		{"millisecond", time.Millisecond, time.Unix(0, 2_500_000), time.Unix(0, 3_000_000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := &DataCollector{interval: tt.interval}
+			if got := dc.nextBoundary(tt.now); !got.Equal(tt.want) {
+				t.Errorf("nextBoundary(%v) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectorCountsConcurrentReports(t *testing.T) {
+	tests := []struct {
+		name       string
+		goroutines int
+		reports    int
+	}{
+		{"one goroutine", 1, 10000},
+		{"many goroutines", 64, 2000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+
+			var wg sync.WaitGroup
+			for i := 0; i < tt.goroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < tt.reports; j++ {
+						dc.ReportDuration(time.Now(), time.Millisecond, j%10 != 0)
+					}
+				}()
+			}
+			wg.Wait()
+			trips := collect(t, dc)
+
+			want := int64(tt.goroutines * tt.reports)
+			if got := dc.GetTotalRequests(); got != want {
+				t.Errorf("GetTotalRequests() = %d, want %d", got, want)
+			}
+			if got := trips.count(); got != want {
+				t.Errorf("published %d trips, want %d", got, want)
+			}
+			if got := trips.Failures.Count(); got != want/10 {
+				t.Errorf("published %d failures, want %d", got, want/10)
+			}
+		})
+	}
+}
+
+func TestCollectorPublishesEveryInterval(t *testing.T) {
+	const (
+		interval = 100 * time.Millisecond
+		idle     = 3
+	)
+
+	dc := newDataCollector(DefaultCollectorConfig(), interval)
+	dc.Report(time.Now(), true)
+
+	// Intervals are published a grace period after they end, idle ones too
+	var published []*TripsOfSec
+	for len(published) < idle+1 {
+		select {
+		case trips := <-dc.ResultChan:
+			published = append(published, trips)
+		case <-time.After(bucketGrace + time.Second):
+			t.Fatalf("%d intervals published while running, want %d", len(published), idle+1)
+		}
+	}
+
+	// The interval running at Stop is published although it has not ended
+	dc.Report(time.Now(), true)
+	stopping := time.Now()
+	dc.Stop()
+	for trips := range dc.ResultChan {
+		published = append(published, trips)
+	}
+
+	var total int64
+	for i, trips := range published {
+		total += trips.count()
+		if i > 0 && !trips.Start.Equal(published[i-1].Start.Add(interval)) {
+			t.Errorf("interval %d starts at %v, want right after %v", i, trips.Start, published[i-1].Start)
+		}
+	}
+	if total != 2 {
+		t.Errorf("published %d trips, want 2", total)
+	}
+
+	// Only one of the intervals published while running held a trip
+	empty := 0
+	for _, trips := range published[:idle+1] {
+		if trips.count() == 0 {
+			empty++
+		}
+	}
+	if empty < idle {
+		t.Errorf("%d of the intervals published while running are empty, want %d", empty, idle)
+	}
+
+	final := published[len(published)-1]
+	if end := final.Start.Add(interval); !end.After(stopping) {
+		t.Errorf("last interval ends at %v, want the one running at Stop (%v)", end, stopping)
+	}
+	if final.count() != 1 {
+		t.Errorf("last interval holds %d trips, want the one reported before Stop", final.count())
+	}
+}
+
+// benchmarkCollector returns a collector whose published intervals are
+// consumed like a session would, and stops it when the benchmark ends
+func benchmarkCollector(b *testing.B, config CollectorConfig) *DataCollector {
+	dc := newDataCollector(config, DefaultInterval)
+	go func() {
+		for range dc.ResultChan {
+		}
+	}()
+	b.Cleanup(dc.Stop)
+	return dc
+}
+
+func BenchmarkReportTrip(b *testing.B) {
+	dc := benchmarkCollector(b, DefaultCollectorConfig())
+	start := time.Now()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dc.ReportTrip(&Trip{StartTime: start, EndTime: time.Now(), Success: true})
+	}
+}
+
+func BenchmarkReportTripParallel(b *testing.B) {
+	dc := benchmarkCollector(b, DefaultCollectorConfig())
+	start := time.Now()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			dc.ReportTrip(&Trip{StartTime: start, EndTime: time.Now(), Success: true})
+		}
+	})
+}
+
+func BenchmarkReportTripLabeled(b *testing.B) {
+	dc := benchmarkCollector(b, DefaultCollectorConfig())
+	start := time.Now()
+	labels := map[string]string{OperationLabel: "search"}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			dc.ReportTrip(&Trip{StartTime: start, EndTime: time.Now(), Success: true, Labels: labels})
+		}
+	})
+}
+
+func BenchmarkReportTripSampled(b *testing.B) {
+	dc := benchmarkCollector(b, CollectorConfig{Backpressure: BackpressureSample, SampleRate: 0.1})
+	start := time.Now()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			dc.ReportTrip(&Trip{StartTime: start, EndTime: time.Now(), Success: true})
+		}
+	})
+}
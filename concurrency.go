@@ -0,0 +1,214 @@
+package ptest
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Token tracks one in-flight request from Begin to End. Only the first End,
+// EndError or Discard counts, also when called concurrently; a Token without
+// a running session is a no-op.
+type Token struct {
+	collector *DataCollector
+	labels    map[string]string
+	begin     time.Time
+	started   time.Time
+	ended     atomic.Bool
+
+	// intended is the scheduled start of executor iterations
+	intended time.Time
+	// tracer records the phases of a traced HTTP request
+	tracer *phaseTracer
+}
+
+// Start marks the moment the request leaves the queue, e.g. once a worker or
+// connection is available. The time between Begin and Start is the queue
+// time; the response time is still measured from Begin.
+func (t *Token) Start() {
+	if t.collector != nil && t.started.IsZero() {
+		t.started = time.Now()
+	}
+}
+
+// SetLabels replaces the labels the request is reported with, for labels
+// only known at its end such as a status code
+func (t *Token) SetLabels(labels map[string]string) {
+	if t.collector != nil {
+		t.labels = copyLabels(labels)
+	}
+}
+
+// End reports the request as finished now
+func (t *Token) End(success bool) {
+	if !t.finish() {
+		return
+	}
+
+	trip := t.trip()
+	trip.Success = success
+	t.collector.record(&trip)
+}
+
+// EndError reports the request as finished now; a nil error is a success
+func (t *Token) EndError(err error) {
+	if !t.finish() {
+		return
+	}
+
+	trip := t.trip()
+	trip.Success = err == nil
+	t.collector.setError(&trip, err)
+	t.collector.record(&trip)
+}
+
+// Discard ends the token without reporting the request, e.g. because the
+// request is reported another way
+func (t *Token) Discard() {
+	t.finish()
+}
+
+// finish marks the token as ended and reports whether it was still in flight
+func (t *Token) finish() bool {
+	if t.collector == nil || !t.ended.CompareAndSwap(false, true) {
+		return false
+	}
+
+	atomic.AddInt64(&t.collector.inFlight, -1)
+	return true
+}
+
+// trip builds the trip of a finished token
+func (t *Token) trip() Trip {
+	trip := Trip{
+		StartTime:         t.begin,
+		IntendedStartTime: t.intended,
+		EndTime:           time.Now(),
+		Labels:            t.labels,
+	}
+	if !t.started.IsZero() {
+		trip.QueueTime = t.started.Sub(t.begin)
+	}
+	if t.tracer != nil {
+		trip.Phases = t.tracer.result()
+		trip.StatusCode, trip.ResponseSize = t.tracer.response()
+	}
+	return trip
+}
+
+// concurrencySample is the in-flight count of one interval
+type concurrencySample struct {
+	inFlight int64
+	peak     int64
+}
+
+// Begin starts tracking a request that begins now
+func (dc *DataCollector) Begin() *Token {
+	return dc.begin(nil)
+}
+
+// BeginWithLabels starts tracking a labeled request that begins now
+func (dc *DataCollector) BeginWithLabels(labels map[string]string) *Token {
+	return dc.begin(labels)
+}
+
+// begin counts a request as in flight and raises the interval's peak
+func (dc *DataCollector) begin(labels map[string]string) *Token {
+	if !dc.running.Load() {
+		return &Token{}
+	}
+
+	inFlight := atomic.AddInt64(&dc.inFlight, 1)
+	for {
+		peak := atomic.LoadInt64(&dc.peakInFlight)
+		if inFlight <= peak || atomic.CompareAndSwapInt64(&dc.peakInFlight, peak, inFlight) {
+			break
+		}
+	}
+
+	return &Token{
+		collector: dc,
+		labels:    labels,
+		begin:     time.Now(),
+	}
+}
+
+// GetInFlight returns the number of requests between Begin and End
+func (dc *DataCollector) GetInFlight() int64 {
+	return atomic.LoadInt64(&dc.inFlight)
+}
+
+// sampleConcurrency records the in-flight count and peak of an interval that
+// just ended; the next interval's peak starts at the current in-flight count
+func (dc *DataCollector) sampleConcurrency(index int64) {
+	inFlight := atomic.LoadInt64(&dc.inFlight)
+	dc.concurrency[index] = concurrencySample{
+		inFlight: inFlight,
+		peak:     atomic.SwapInt64(&dc.peakInFlight, inFlight),
+	}
+}
+
+// setConcurrency attaches the concurrency sample of its interval to a bucket
+// about to be published. Intervals the run loop missed carry the last sample.
+func (dc *DataCollector) setConcurrency(index int64, bucket *TripsOfSec) {
+	sample, ok := dc.concurrency[index]
+	if ok {
+		delete(dc.concurrency, index)
+		dc.lastConcurrency = sample
+	} else {
+		sample = concurrencySample{
+			inFlight: dc.lastConcurrency.inFlight,
+			peak:     dc.lastConcurrency.inFlight,
+		}
+	}
+
+	bucket.InFlight = sample.inFlight
+	bucket.PeakInFlight = sample.peak
+}
+
+// Begin starts tracking a request of the session that begins now
+func (ts *TestSession) Begin() *Token {
+	if !ts.running() {
+		return &Token{}
+	}
+
+	return ts.dataCollector.Begin()
+}
+
+// BeginWithLabels starts tracking a labeled request of the session that begins now
+func (ts *TestSession) BeginWithLabels(labels map[string]string) *Token {
+	if !ts.running() {
+		return &Token{}
+	}
+
+	return ts.dataCollector.BeginWithLabels(labels)
+}
+
+// Begin starts tracking a request of the current session that begins now.
+// Without a running session the returned token is a no-op.
+func (tr *TestRunner) Begin() *Token {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return &Token{}
+	}
+	return session.Begin()
+}
+
+// BeginWithLabels starts tracking a labeled request of the current session that begins now
+func (tr *TestRunner) BeginWithLabels(labels map[string]string) *Token {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return &Token{}
+	}
+	return session.BeginWithLabels(labels)
+}
+
+// Begin starts tracking a request of the operation that begins now
+func (op *Operation) Begin() *Token {
+	return op.runner.BeginWithLabels(op.labels)
+}
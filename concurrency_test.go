@@ -0,0 +1,73 @@
+package ptest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestTokenEndsOnce(t *testing.T) {
+	tests := []struct {
+		name        string
+		end         func(tok *Token)
+		wantSuccess int64
+		wantFailure int64
+	}{
+		{"end", func(tok *Token) { tok.End(true) }, 1, 0},
+		{"end twice", func(tok *Token) { tok.End(true); tok.End(false) }, 1, 0},
+		{"error then end", func(tok *Token) { tok.EndError(errors.New("boom")); tok.End(true) }, 0, 1},
+		{"discard then end", func(tok *Token) { tok.Discard(); tok.End(true) }, 0, 0},
+		{"concurrent ends", func(tok *Token) {
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					tok.End(true)
+				}()
+			}
+			wg.Wait()
+		}, 1, 0},
+		{"shared by handles", func(tok *Token) {
+			other := tok
+			tok.End(true)
+			other.End(true)
+		}, 1, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+			tok := dc.Begin()
+			if got := dc.GetInFlight(); got != 1 {
+				t.Fatalf("in flight %d after Begin, want 1", got)
+			}
+
+			tt.end(tok)
+			if got := dc.GetInFlight(); got != 0 {
+				t.Errorf("in flight %d after ending, want 0", got)
+			}
+
+			trips := collect(t, dc)
+			if trips.Success.Count() != tt.wantSuccess || trips.Failures.Count() != tt.wantFailure {
+				t.Errorf("recorded %d successes and %d failures, want %d and %d",
+					trips.Success.Count(), trips.Failures.Count(), tt.wantSuccess, tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestTokenWithoutSession(t *testing.T) {
+	dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+	dc.Stop()
+
+	tok := dc.Begin()
+	tok.Start()
+	tok.SetLabels(map[string]string{"a": "b"})
+	tok.End(true)
+	tok.Discard()
+
+	if got := dc.GetInFlight(); got != 0 {
+		t.Errorf("in flight %d, want 0", got)
+	}
+}
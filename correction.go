@@ -0,0 +1,130 @@
+package ptest
+
+import "time"
+
+// Coordinated omission: a worker that paces requests at a fixed rate and is
+// blocked by a slow response sends its next requests late, so the time they
+// spent waiting for their turn never shows up in the response times. Trips
+// that carry the start time their schedule intended are also recorded with
+// the latency measured from that intended start, which gives corrected
+// percentiles next to the raw ones.
+
+// CorrectedDuration returns the response time measured from the intended
+// start time, or the raw response time if the trip has none or started early
+func (t *Trip) CorrectedDuration() time.Duration {
+	if t.IntendedStartTime.IsZero() || !t.IntendedStartTime.Before(t.StartTime) {
+		return t.Duration()
+	}
+	return t.EndTime.Sub(t.IntendedStartTime)
+}
+
+// startCorrecting allocates the corrected histograms of a bucket. Trips
+// recorded so far had no intended start, so their corrected latency is the
+// raw one.
+func (t *TripsOfSec) startCorrecting() {
+	t.CorrectedSuccess = cloneHistogram(t.Success)
+	t.CorrectedFailures = cloneHistogram(t.Failures)
+}
+
+// correctedHistograms returns the corrected histograms of a bucket, which
+// are the raw ones if no trip of the bucket had an intended start
+func (t *TripsOfSec) correctedHistograms() (*Histogram, *Histogram) {
+	if t.CorrectedSuccess == nil {
+		return t.Success, t.Failures
+	}
+	return t.CorrectedSuccess, t.CorrectedFailures
+}
+
+// mergeCorrected merges the corrected histograms of another bucket
+func (t *TripsOfSec) mergeCorrected(other *TripsOfSec) {
+	if other.CorrectedSuccess == nil && t.CorrectedSuccess == nil {
+		return
+	}
+	if t.CorrectedSuccess == nil {
+		t.startCorrecting()
+	}
+
+	success, failures := other.correctedHistograms()
+	t.CorrectedSuccess.Merge(success)
+	t.CorrectedFailures.Merge(failures)
+}
+
+// cloneHistogram returns a copy of a histogram
+func cloneHistogram(h *Histogram) *Histogram {
+	clone := h.newEmptyLike()
+	clone.Merge(h)
+	return clone
+}
+
+// correctedStat calculates the corrected latency of a bucket, or nil if no
+// trip of the bucket had an intended start
+func (da *DataAggregator) correctedStat(trips *TripsOfSec) *Stat {
+	if trips.CorrectedSuccess == nil {
+		return nil
+	}
+
+	corrected := &Stat{
+		SuccessHistogram: trips.CorrectedSuccess,
+		FailureHistogram: trips.CorrectedFailures,
+	}
+	setLatencyStats(corrected, trips.CorrectedSuccess, trips.CorrectedFailures, da.percentiles)
+	return corrected
+}
+
+// aggregateCorrected aggregates the corrected latency of one tier window, or
+// returns nil if none of its stats has any
+func (cdm *ChartDataManager) aggregateCorrected(stats []*Stat) *Stat {
+	var successHistogram, failureHistogram *Histogram
+	corrected := false
+
+	for _, stat := range stats {
+		success, failure := stat.SuccessHistogram, stat.FailureHistogram
+		if stat.Corrected != nil {
+			success, failure = stat.Corrected.SuccessHistogram, stat.Corrected.FailureHistogram
+			corrected = true
+		}
+		successHistogram = mergeHistogram(successHistogram, success)
+		failureHistogram = mergeHistogram(failureHistogram, failure)
+	}
+
+	if !corrected {
+		return nil
+	}
+
+	aggregated := &Stat{}
+	setLatencyStats(aggregated, successHistogram, failureHistogram, cdm.percentiles)
+	return aggregated
+}
+
+// ReportIntended reports a single test result that finished now, with the
+// start time the request was scheduled for by a paced workload
+func (dc *DataCollector) ReportIntended(intended, start time.Time, success bool) {
+	dc.record(&Trip{
+		StartTime:         start,
+		IntendedStartTime: intended,
+		EndTime:           time.Now(),
+		Success:           success,
+	})
+}
+
+// ReportIntended reports a test result with the start time the request was
+// scheduled for, so latency can be corrected for coordinated omission
+func (ts *TestSession) ReportIntended(intended, start time.Time, success bool) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.ReportIntended(intended, start, success)
+}
+
+// ReportIntended reports a test result to the current session with the start
+// time the request was scheduled for
+func (tr *TestRunner) ReportIntended(intended, start time.Time, success bool) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil && session.running() {
+		session.ReportIntended(intended, start, success)
+	}
+}
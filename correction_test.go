@@ -0,0 +1,90 @@
+package ptest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCorrectedDuration(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := start.Add(10 * time.Millisecond)
+
+	tests := []struct {
+		name     string
+		intended time.Time
+		want     time.Duration
+	}{
+		{"no intended start", time.Time{}, 10 * time.Millisecond},
+		{"on time", start, 10 * time.Millisecond},
+		{"started late", start.Add(-40 * time.Millisecond), 50 * time.Millisecond},
+		{"started early", start.Add(5 * time.Millisecond), 10 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trip := Trip{StartTime: start, EndTime: end, IntendedStartTime: tt.intended}
+			if got := trip.CorrectedDuration(); got != tt.want {
+				t.Errorf("CorrectedDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionCorrectedLatency(t *testing.T) {
+	tests := []struct {
+		name      string
+		delay     time.Duration
+		wantRaw   float64
+		wantFixed float64
+	}{
+		{"without intended starts", 0, 10, 0},
+		{"delayed by 90ms", 90 * time.Millisecond, 10, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				for i := 0; i < 100; i++ {
+					end := time.Now()
+					trip := Trip{StartTime: end.Add(-10 * time.Millisecond), EndTime: end, Success: true}
+					if tt.delay > 0 {
+						trip.IntendedStartTime = trip.StartTime.Add(-tt.delay)
+					}
+					ts.ReportTrip(&trip)
+				}
+			})
+
+			raw, _ := ts.GetLatencySummaries()
+			if raw == nil || raw.Max < tt.wantRaw*0.99 || raw.Max > tt.wantRaw*1.01 {
+				t.Fatalf("raw latency summary %+v, want max %vms", raw, tt.wantRaw)
+			}
+
+			corrected, _ := ts.GetCorrectedLatencySummaries()
+			if tt.wantFixed == 0 {
+				if corrected != nil {
+					t.Errorf("corrected summary %+v without intended starts", corrected)
+				}
+				return
+			}
+			if corrected == nil || corrected.Max < tt.wantFixed*0.99 || corrected.Max > tt.wantFixed*1.01 {
+				t.Errorf("corrected latency summary %+v, want max %vms", corrected, tt.wantFixed)
+			}
+		})
+	}
+}
+
+func TestSessionStartResetsCorrection(t *testing.T) {
+	ts := newTestSession("test", t.Name(), SessionConfig{})
+	ts.correcting = true
+	ts.correctedSuccessHistogram = NewHistogram(DefaultHistogramPrecision)
+	ts.correctedFailureHistogram = NewHistogram(DefaultHistogramPrecision)
+
+	ts.start()
+	defer ts.stop()
+
+	ts.histogramMutex.RLock()
+	defer ts.histogramMutex.RUnlock()
+	if ts.correcting || ts.correctedSuccessHistogram != nil || ts.correctedFailureHistogram != nil {
+		t.Error("start kept the corrected latency of an earlier run")
+	}
+}
@@ -0,0 +1,181 @@
+package ptest
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoSession is returned by load drivers started without a running test session
+var ErrNoSession = errors.New("ptest: no running test session")
+
+// VU is one virtual user of a closed-model load test. A virtual user runs
+// one iteration at a time and starts the next one after the previous one
+// finished and its think time passed.
+type VU struct {
+	// ID numbers the virtual users from 0
+	ID int
+	// Iteration counts the iterations of the virtual user; it is the number
+	// of the running iteration, starting at 0
+	Iteration int64
+	// ThinkTime is waited after the running iteration. It is drawn from the
+	// configured think time before every iteration; the iteration function
+	// may change it.
+	ThinkTime time.Duration
+
+	// retired is set when a load profile no longer needs the virtual user
+	retired atomic.Bool
+}
+
+// ThinkTime is the pause of a virtual user between its iterations, drawn
+// uniformly from Min to Max. A Max not above Min waits Min every time.
+type ThinkTime struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// next draws the think time of an iteration
+func (t ThinkTime) next() time.Duration {
+	if t.Max <= t.Min {
+		return t.Min
+	}
+	return t.Min + rand.N(t.Max-t.Min+1)
+}
+
+// ClosedConfig configures the closed-model executor
+type ClosedConfig struct {
+	// Users is the number of virtual users
+	Users int
+	// ThinkTime is waited after every iteration of a virtual user
+	ThinkTime ThinkTime
+	// SelfReported skips the driver's report of every call, for functions
+	// that report their requests themselves, e.g. httptarget's Target.RunVU
+	// or requests sent through a Transport
+	SelfReported bool
+}
+
+// RunClosed runs a closed-model load test against the current session:
+// users virtual users call fn in a loop, and every call is timed and
+// reported, with its error classified like ReportError. RunClosed blocks
+// until ctx is done or the session is stopped; the context passed to fn is
+// canceled then, and calls interrupted by the shutdown are not reported.
+func (tr *TestRunner) RunClosed(ctx context.Context, users int, fn func(ctx context.Context, vu *VU) error) error {
+	return tr.RunClosedWithConfig(ctx, ClosedConfig{Users: users}, fn)
+}
+
+// RunClosedWithConfig runs a closed-model load test like RunClosed, with the
+// virtual users, their think time and whether the calls are reported taken
+// from config
+func (tr *TestRunner) RunClosedWithConfig(ctx context.Context, config ClosedConfig, fn func(ctx context.Context, vu *VU) error) error {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return ErrNoSession
+	}
+	return session.RunClosedWithConfig(ctx, config, fn)
+}
+
+// RunClosed runs a closed-model load test against the session; see
+// TestRunner.RunClosed
+func (ts *TestSession) RunClosed(ctx context.Context, users int, fn func(ctx context.Context, vu *VU) error) error {
+	return ts.RunClosedWithConfig(ctx, ClosedConfig{Users: users}, fn)
+}
+
+// RunClosedWithConfig runs a closed-model load test against the session; see
+// TestRunner.RunClosedWithConfig
+func (ts *TestSession) RunClosedWithConfig(ctx context.Context, config ClosedConfig, fn func(ctx context.Context, vu *VU) error) error {
+	if !ts.running() {
+		return ErrNoSession
+	}
+
+	ctx, cancel := ts.runContext(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < config.Users; i++ {
+		wg.Add(1)
+		go func(vu *VU) {
+			defer wg.Done()
+			ts.runVU(ctx, vu, config, fn)
+		}(&VU{ID: i})
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// iterate times and reports one call of a load driver. It reports false if
+// ctx was done by the end of the call; such calls are not reported, as being
+// cut short by the shutdown they say nothing about the system under test.
+func (ts *TestSession) iterate(ctx context.Context, tok *Token, call func(ctx context.Context) error) bool {
+	err := call(ctx)
+
+	if ctx.Err() != nil {
+		tok.Discard()
+		return false
+	}
+
+	tok.EndError(err)
+	return true
+}
+
+// beginCall returns the token a load driver reports a call with, or one that
+// reports nothing if the calls report their requests themselves
+func (ts *TestSession) beginCall(selfReported bool) *Token {
+	if selfReported {
+		return &Token{}
+	}
+	return ts.dataCollector.Begin()
+}
+
+// runContext returns a context that is also canceled when the session stops
+func (ts *TestSession) runContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-ts.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// runVU runs the iterations of one virtual user until ctx is done or the
+// virtual user is retired
+func (ts *TestSession) runVU(ctx context.Context, vu *VU, config ClosedConfig, fn func(ctx context.Context, vu *VU) error) {
+	call := func(ctx context.Context) error { return fn(ctx, vu) }
+
+	for ; ctx.Err() == nil && !vu.retired.Load(); vu.Iteration++ {
+		vu.ThinkTime = config.ThinkTime.next()
+		if !ts.iterate(ctx, ts.beginCall(config.SelfReported), call) {
+			return
+		}
+
+		if !sleepContext(ctx, vu.ThinkTime) {
+			return
+		}
+	}
+}
+
+// sleepContext waits for d and reports whether ctx is still running afterwards
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
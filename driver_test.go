@@ -0,0 +1,152 @@
+package ptest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThinkTimeNext(t *testing.T) {
+	tests := []struct {
+		name      string
+		thinkTime ThinkTime
+		min, max  time.Duration
+	}{
+		{"none", ThinkTime{}, 0, 0},
+		{"fixed", ThinkTime{Min: 50 * time.Millisecond}, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"max below min", ThinkTime{Min: 50 * time.Millisecond, Max: 10 * time.Millisecond}, 50 * time.Millisecond, 50 * time.Millisecond},
+		{"range", ThinkTime{Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}, 10 * time.Millisecond, 20 * time.Millisecond},
+		{"up to max", ThinkTime{Max: time.Second}, 0, time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if got := tt.thinkTime.next(); got < tt.min || got > tt.max {
+					t.Fatalf("next() = %v, want between %v and %v", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRunClosedThinkTime(t *testing.T) {
+	const (
+		users    = 3
+		duration = 250 * time.Millisecond
+	)
+
+	tests := []struct {
+		name          string
+		thinkTime     ThinkTime
+		override      time.Duration
+		minIterations int64
+		maxIterations int64
+	}{
+		// Iterations start at 0, 100 and 200ms
+		{"configured", ThinkTime{Min: 100 * time.Millisecond}, 0, 2, 3},
+		{"overridden by fn", ThinkTime{Min: time.Second}, 100 * time.Millisecond, 2, 3},
+		{"none", ThinkTime{}, 0, 10, 1 << 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			iterations := make(map[int]int64)
+
+			runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithTimeout(context.Background(), duration)
+				defer cancel()
+
+				config := ClosedConfig{Users: users, ThinkTime: tt.thinkTime}
+				err := ts.RunClosedWithConfig(ctx, config, func(ctx context.Context, vu *VU) error {
+					if tt.override > 0 {
+						vu.ThinkTime = tt.override
+					}
+					mutex.Lock()
+					iterations[vu.ID] = vu.Iteration + 1
+					mutex.Unlock()
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("RunClosedWithConfig() = %v", err)
+				}
+			})
+
+			if len(iterations) != users {
+				t.Fatalf("%d virtual users ran, want %d", len(iterations), users)
+			}
+			for id, n := range iterations {
+				if n < tt.minIterations || n > tt.maxIterations {
+					t.Errorf("virtual user %d ran %d iterations, want %d to %d", id, n, tt.minIterations, tt.maxIterations)
+				}
+			}
+		})
+	}
+}
+
+func TestSelfReported(t *testing.T) {
+	const calls = 3
+
+	closed := func(ctx context.Context, ts *TestSession, selfReported bool, call func(ctx context.Context) error) {
+		config := ClosedConfig{Users: 1, SelfReported: selfReported}
+		ts.RunClosedWithConfig(ctx, config, func(ctx context.Context, vu *VU) error { return call(ctx) })
+	}
+	open := func(ctx context.Context, ts *TestSession, selfReported bool, call func(ctx context.Context) error) {
+		ts.RunOpen(ctx, OpenConfig{Rate: 100, MaxWorkers: 1, SelfReported: selfReported}, call)
+	}
+
+	tests := []struct {
+		name         string
+		run          func(ctx context.Context, ts *TestSession, selfReported bool, call func(ctx context.Context) error)
+		selfReported bool
+		wantRequests int64
+	}{
+		{"closed", closed, false, 3 * calls},
+		{"closed self-reported", closed, true, 2 * calls},
+		{"open", open, false, 3 * calls},
+		{"open self-reported", open, true, 2 * calls},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				// Every call sends two requests and swallows the failure of
+				// the second one; the call after the last one stops the driver
+				started := 0
+				tt.run(ctx, ts, tt.selfReported, func(ctx context.Context) error {
+					if started++; started > calls {
+						cancel()
+						return nil
+					}
+					ts.BeginWithLabels(map[string]string{OperationLabel: "first"}).End(true)
+					ts.BeginWithLabels(map[string]string{OperationLabel: "second"}).EndError(errors.New("connection reset"))
+					return nil
+				})
+			})
+
+			if got := ts.cumulativeStats.totalRequests(); got != tt.wantRequests {
+				t.Errorf("session counts %d requests, want %d", got, tt.wantRequests)
+			}
+			operations := make(map[string]*LabelStats)
+			for _, stats := range ts.GetLabelStats() {
+				operations[stats.Labels[OperationLabel]] = stats
+			}
+			first, second := operations["first"], operations["second"]
+			if len(operations) != 2 || first == nil || second == nil {
+				t.Fatalf("got operations %v, want first and second", operations)
+			}
+			if first.TotalRequests != calls || first.CumulativeErrorRate != 0 {
+				t.Errorf("first reported %d requests with error rate %v%%, want %d successes", first.TotalRequests, first.CumulativeErrorRate, calls)
+			}
+			if second.TotalRequests != calls || second.CumulativeErrorRate != 100 {
+				t.Errorf("second reported %d requests with error rate %v%%, want %d failures", second.TotalRequests, second.CumulativeErrorRate, calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package ptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// Error classes assigned by DefaultErrorClassifier
+const (
+	ErrorClassUnclassified = "unclassified"
+	ErrorClassTimeout      = "timeout"
+	ErrorClassCanceled     = "canceled"
+	ErrorClassNetwork      = "network"
+	ErrorClassClient       = "4xx"
+	ErrorClassServer       = "5xx"
+	ErrorClassError        = "error"
+)
+
+// maxErrorSamples is how many sample messages are kept per error class
+const maxErrorSamples = 5
+
+// ErrorClassifier maps an error to an error class shown on the dashboard
+type ErrorClassifier func(err error) string
+
+// StatusError is an error carrying a status code, such as an HTTP status
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+// NewStatusError creates an error for a status code
+func NewStatusError(code int, message string) *StatusError {
+	return &StatusError{Code: code, Message: message}
+}
+
+// Error implements the error interface
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("status %d", e.Code)
+	}
+	return fmt.Sprintf("status %d: %s", e.Code, e.Message)
+}
+
+// ClassifyStatusCode maps a status code to an error class
+func ClassifyStatusCode(code int) string {
+	switch {
+	case code >= 500 && code < 600:
+		return ErrorClassServer
+	case code >= 400 && code < 500:
+		return ErrorClassClient
+	default:
+		return fmt.Sprintf("status_%d", code)
+	}
+}
+
+// ClassifiedError is an error that names its own error class
+type ClassifiedError interface {
+	error
+	ErrorClass() string
+}
+
+// DefaultErrorClassifier classifies timeouts, cancellations, network errors
+// and status codes, and puts everything else in ErrorClassError. Errors
+// wrapping a ClassifiedError get its class.
+func DefaultErrorClassifier(err error) string {
+	var classified ClassifiedError
+	var statusErr *StatusError
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &classified):
+		return classified.ErrorClass()
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.As(err, &statusErr):
+		return ClassifyStatusCode(statusErr.Code)
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorClassTimeout
+		}
+		return ErrorClassNetwork
+	default:
+		return ErrorClassError
+	}
+}
+
+// ErrorClassStats contains cumulative statistics for one error class
+type ErrorClassStats struct {
+	Class    string    `json:"class"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+	Samples  []string  `json:"samples,omitempty"`
+}
+
+// errorClassTable keeps per-session error class statistics
+type errorClassTable struct {
+	classes map[string]*ErrorClassStats
+}
+
+// newErrorClassTable creates an empty error class table
+func newErrorClassTable() *errorClassTable {
+	return &errorClassTable{
+		classes: make(map[string]*ErrorClassStats),
+	}
+}
+
+// add adds the error classes of a stat to the table
+func (t *errorClassTable) add(stat *Stat) {
+	seen := time.Unix(stat.Time, 0)
+
+	for class, count := range stat.ErrorClasses {
+		entry, ok := t.classes[class]
+		if !ok {
+			entry = &ErrorClassStats{Class: class}
+			t.classes[class] = entry
+		}
+		entry.Count += int64(count)
+		entry.LastSeen = seen
+
+		for _, sample := range stat.ErrorSamples[class] {
+			entry.addSample(sample)
+		}
+	}
+}
+
+// addSample keeps the most recent distinct sample messages
+func (e *ErrorClassStats) addSample(sample string) {
+	for i, existing := range e.Samples {
+		if existing == sample {
+			// Move to the end as the most recent one
+			e.Samples = append(append(e.Samples[:i:i], e.Samples[i+1:]...), sample)
+			return
+		}
+	}
+
+	e.Samples = append(e.Samples, sample)
+	if len(e.Samples) > maxErrorSamples {
+		e.Samples = e.Samples[len(e.Samples)-maxErrorSamples:]
+	}
+}
+
+// list returns copies of all entries, most frequent first
+func (t *errorClassTable) list() []*ErrorClassStats {
+	result := make([]*ErrorClassStats, 0, len(t.classes))
+	for _, entry := range t.classes {
+		copied := *entry
+		copied.Samples = append([]string(nil), entry.Samples...)
+		result = append(result, &copied)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Class < result[j].Class
+	})
+	return result
+}
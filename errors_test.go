@@ -0,0 +1,102 @@
+package ptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// classError is an error that names its own class
+type classError struct{ class string }
+
+func (e *classError) Error() string      { return "classified " + e.class }
+func (e *classError) ErrorClass() string { return e.class }
+
+// netError is a net.Error that may time out
+type netError struct{ timeout bool }
+
+func (e *netError) Error() string   { return "network failure" }
+func (e *netError) Timeout() bool   { return e.timeout }
+func (e *netError) Temporary() bool { return false }
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"classified", &classError{"quota"}, "quota"},
+		{"classified wrapped", fmt.Errorf("call: %w", &classError{"quota"}), "quota"},
+		{"classified over deadline", errors.Join(context.DeadlineExceeded, &classError{"quota"}), "quota"},
+		{"deadline", context.DeadlineExceeded, ErrorClassTimeout},
+		{"deadline over canceled", errors.Join(context.Canceled, context.DeadlineExceeded), ErrorClassTimeout},
+		{"canceled", fmt.Errorf("call: %w", context.Canceled), ErrorClassCanceled},
+		{"canceled over status", errors.Join(NewStatusError(503, ""), context.Canceled), ErrorClassCanceled},
+		{"client status", NewStatusError(404, "not found"), ErrorClassClient},
+		{"server status", fmt.Errorf("call: %w", NewStatusError(503, "")), ErrorClassServer},
+		{"status over network", errors.Join(&netError{}, NewStatusError(500, "")), ErrorClassServer},
+		{"network timeout", &netError{timeout: true}, ErrorClassTimeout},
+		{"network", fmt.Errorf("dial: %w", &netError{}), ErrorClassNetwork},
+		{"other", errors.New("parse failed"), ErrorClassError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DefaultErrorClassifier(tt.err); got != tt.want {
+				t.Errorf("DefaultErrorClassifier(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyStatusCode(t *testing.T) {
+	tests := []struct {
+		code int
+		want string
+	}{
+		{400, ErrorClassClient},
+		{499, ErrorClassClient},
+		{500, ErrorClassServer},
+		{599, ErrorClassServer},
+		{302, "status_302"},
+		{600, "status_600"},
+	}
+
+	for _, tt := range tests {
+		if got := ClassifyStatusCode(tt.code); got != tt.want {
+			t.Errorf("ClassifyStatusCode(%d) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestErrorClassSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []string
+		want    []string
+	}{
+		{"distinct", []string{"a", "b", "c"}, []string{"a", "b", "c"}},
+		{"repeated moves to the end", []string{"a", "b", "a"}, []string{"b", "a"}},
+		{"repeated last stays", []string{"a", "b", "b"}, []string{"a", "b"}},
+		{"oldest evicted", []string{"a", "b", "c", "d", "e", "f", "g"}, []string{"c", "d", "e", "f", "g"}},
+		{"repeat keeps it from eviction", []string{"a", "b", "c", "d", "e", "a", "f"}, []string{"c", "d", "e", "a", "f"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &ErrorClassStats{}
+			for _, sample := range tt.samples {
+				entry.addSample(sample)
+			}
+			if !reflect.DeepEqual(entry.Samples, tt.want) {
+				t.Errorf("samples %v, want %v", entry.Samples, tt.want)
+			}
+			if len(entry.Samples) > maxErrorSamples {
+				t.Errorf("kept %d samples, want at most %d", len(entry.Samples), maxErrorSamples)
+			}
+		})
+	}
+}
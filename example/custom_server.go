@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
-	"sync"
 	"time"
 
 	"github.com/realcoke/ptest"
@@ -55,68 +55,55 @@ func main() {
 	log.Println("Starting performance test session...")
 	runner.StartTest("Custom Server Load Test")
 
-	// Simulate test workload
-	stop := false
-	var wg sync.WaitGroup
+	// Stop on Ctrl+C
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	log.Printf("Performance test running with 100 concurrent users")
+	log.Println("Press Ctrl+C to stop...")
 
 	// Simulate 100 concurrent users with varying load
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(userID int) {
-			defer wg.Done()
-
-			for !stop {
-				start := time.Now()
-
-				// Simulate different types of operations with different durations
-				operationType := rand.Intn(3)
-				var workDuration time.Duration
-				var successRate int
-
-				switch operationType {
-				case 0: // Fast operation
-					workDuration = time.Duration(rand.Intn(50)+10) * time.Millisecond
-					successRate = 95
-				case 1: // Medium operation
-					workDuration = time.Duration(rand.Intn(100)+50) * time.Millisecond
-					successRate = 90
-				case 2: // Slow operation
-					workDuration = time.Duration(rand.Intn(200)+100) * time.Millisecond
-					successRate = 85
-				}
-
-				time.Sleep(workDuration)
-
-				success := rand.Intn(100) < successRate
-				runner.Report(start, success)
-
-				// Random think time between requests
-				thinkTime := time.Duration(rand.Intn(1000)) * time.Millisecond
-				time.Sleep(thinkTime)
-			}
-		}(i)
+	// Random think time between requests
+	config := ptest.ClosedConfig{
+		Users:     100,
+		ThinkTime: ptest.ThinkTime{Max: time.Second},
 	}
+	runner.RunClosedWithConfig(ctx, config, func(ctx context.Context, vu *ptest.VU) error {
+		// Simulate different types of operations with different durations
+		operationType := rand.Intn(3)
+		var workDuration time.Duration
+		var successRate int
+
+		switch operationType {
+		case 0: // Fast operation
+			workDuration = time.Duration(rand.Intn(50)+10) * time.Millisecond
+			successRate = 95
+		case 1: // Medium operation
+			workDuration = time.Duration(rand.Intn(100)+50) * time.Millisecond
+			successRate = 90
+		case 2: // Slow operation
+			workDuration = time.Duration(rand.Intn(200)+100) * time.Millisecond
+			successRate = 85
+		}
 
-	log.Printf("Performance test running with 100 concurrent users")
-	log.Println("Press Ctrl+C to stop...")
+		time.Sleep(workDuration)
 
-	// Wait for interrupt signal
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+		if rand.Intn(100) >= successRate {
+			return errors.New("simulated failure")
+		}
+		return nil
+	})
 
 	log.Println("Shutting down...")
-	stop = true
 	runner.StopTest()
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
 
-	wg.Wait()
 	log.Println("Application stopped")
 }
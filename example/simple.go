@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"math/rand"
 	"os"
 	"os/signal"
-	"sync"
 	"time"
 
 	"github.com/realcoke/ptest"
 )
 
+var errSimulated = errors.New("simulated failure")
+
 func main() {
 	log.Println("Initializing Performance Test Runner...")
 
@@ -22,46 +25,28 @@ func main() {
 	log.Println("Starting performance test session...")
 	runner.StartTest("Simple Load Test")
 
-	// Run test workload
-	stop := false
-	var wg sync.WaitGroup
-
-	// Simulate 50 concurrent users
-	for i := 0; i < 50; i++ {
-		wg.Add(1)
-		go func(userID int) {
-			defer wg.Done()
-
-			for !stop {
-				// Simulate work
-				start := time.Now()
-
-				// Random work duration (10-100ms)
-				workDuration := time.Duration(rand.Intn(90)+10) * time.Millisecond
-				time.Sleep(workDuration)
-
-				// Random success/failure (90% success rate)
-				success := rand.Intn(100) < 90
-
-				// Report test result
-				runner.Report(start, success)
-			}
-		}(i)
-	}
+	// Stop on Ctrl+C
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
 
 	log.Printf("Performance test running with 50 concurrent users")
 	log.Printf("Dashboard available at: http://localhost:9090/ptest/")
 	log.Println("Press Ctrl+C to stop...")
 
-	// Wait for interrupt signal
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	// Simulate 50 concurrent users; every call is timed and reported
+	runner.RunClosed(ctx, 50, func(ctx context.Context, vu *ptest.VU) error {
+		// Random work duration (10-100ms)
+		workDuration := time.Duration(rand.Intn(90)+10) * time.Millisecond
+		time.Sleep(workDuration)
+
+		// Random success/failure (90% success rate)
+		if rand.Intn(100) >= 90 {
+			return errSimulated
+		}
+		return nil
+	})
 
 	log.Println("Stopping test...")
-	stop = true
 	runner.StopTest()
-
-	wg.Wait()
 	log.Println("Test completed")
 }
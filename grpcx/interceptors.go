@@ -0,0 +1,222 @@
+// Package grpcx provides gRPC client and server interceptors that report
+// every call to the current test session of a ptest.TestRunner, labeled by
+// its full method name, status code and side:
+//
+//	reporter := grpcx.New(runner, grpcx.Config{})
+//	conn, err := grpc.NewClient(target, append(reporter.DialOptions(),
+//		grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+//
+//	server := grpc.NewServer(reporter.ServerOptions()...)
+package grpcx
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/realcoke/ptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Label keys set by the interceptors
+const (
+	// MethodLabel holds the full method name of a call, e.g. "/pkg.Service/Method"
+	MethodLabel = "grpc_method"
+	// CodeLabel holds the status code of a call, e.g. "Unavailable"
+	CodeLabel = "grpc_code"
+	// SideLabel holds the side that reported a call, SideClient or SideServer,
+	// so calls between a client and a server in one process are told apart
+	SideLabel = "grpc_side"
+)
+
+// Values of SideLabel
+const (
+	SideClient = "client"
+	SideServer = "server"
+)
+
+// SuccessPolicy decides whether a call that ended with a status code succeeded
+type SuccessPolicy func(code codes.Code) bool
+
+// DefaultSuccess counts only OK as success
+func DefaultSuccess(code codes.Code) bool {
+	return code == codes.OK
+}
+
+// Config configures a Reporter
+type Config struct {
+	// Success maps status codes to success or failure, DefaultSuccess when nil
+	Success SuccessPolicy
+}
+
+// Reporter creates interceptors that report calls to the current session of a runner
+type Reporter struct {
+	runner  *ptest.TestRunner
+	success SuccessPolicy
+}
+
+// New creates a reporter for a runner
+func New(runner *ptest.TestRunner, config Config) *Reporter {
+	if config.Success == nil {
+		config.Success = DefaultSuccess
+	}
+
+	return &Reporter{
+		runner:  runner,
+		success: config.Success,
+	}
+}
+
+// callError is the error a failed call is reported with; its error class is
+// the name of the status code, e.g. "Unavailable"
+type callError struct {
+	code codes.Code
+	err  error
+}
+
+// Error implements the error interface
+func (e *callError) Error() string {
+	if e.err == nil {
+		return e.code.String()
+	}
+	return e.err.Error()
+}
+
+// ErrorClass implements ptest.ClassifiedError
+func (e *callError) ErrorClass() string {
+	return e.code.String()
+}
+
+// call is one reported call
+type call struct {
+	reporter *Reporter
+	method   string
+	side     string
+	tok      *ptest.Token
+	once     sync.Once
+}
+
+// begin starts timing a call of a method on one side
+func (r *Reporter) begin(method, side string) *call {
+	return &call{
+		reporter: r,
+		method:   method,
+		side:     side,
+		tok:      r.runner.BeginWithLabels(map[string]string{MethodLabel: method, SideLabel: side}),
+	}
+}
+
+// end reports the call once with the status code of its error, as a
+// success if the success policy accepts the code
+func (c *call) end(err error) {
+	c.once.Do(func() {
+		code := status.Code(err)
+		c.tok.SetLabels(map[string]string{
+			MethodLabel: c.method,
+			CodeLabel:   code.String(),
+			SideLabel:   c.side,
+		})
+
+		if c.reporter.success(code) {
+			c.tok.End(true)
+			return
+		}
+		c.tok.EndError(&callError{code: code, err: err})
+	})
+}
+
+// UnaryClientInterceptor reports every unary call from the client
+func (r *Reporter) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		c := r.begin(method, SideClient)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.end(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor reports every streaming call from the client once
+// the stream ends: when a receive fails or, without server streaming, the
+// response arrives
+func (r *Reporter) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		c := r.begin(method, SideClient)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.end(err)
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: stream, call: c, serverStreams: desc.ServerStreams}, nil
+	}
+}
+
+// clientStream ends its call when the stream is done
+type clientStream struct {
+	grpc.ClientStream
+	call          *call
+	serverStreams bool
+}
+
+// SendMsg implements grpc.ClientStream
+func (s *clientStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	// io.EOF means the stream failed; the status follows from RecvMsg
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.call.end(err)
+	}
+	return err
+}
+
+// RecvMsg implements grpc.ClientStream
+func (s *clientStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case errors.Is(err, io.EOF):
+		s.call.end(nil)
+	case err != nil:
+		s.call.end(err)
+	case !s.serverStreams:
+		s.call.end(nil)
+	}
+	return err
+}
+
+// UnaryServerInterceptor reports every unary call the server handles
+func (r *Reporter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		c := r.begin(info.FullMethod, SideServer)
+		resp, err := handler(ctx, req)
+		c.end(err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor reports every streaming call the server handles
+func (r *Reporter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		c := r.begin(info.FullMethod, SideServer)
+		err := handler(srv, stream)
+		c.end(err)
+		return err
+	}
+}
+
+// DialOptions returns the options that install the client interceptors
+func (r *Reporter) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(r.UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(r.StreamClientInterceptor()),
+	}
+}
+
+// ServerOptions returns the options that install the server interceptors
+func (r *Reporter) ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(r.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(r.StreamServerInterceptor()),
+	}
+}
@@ -0,0 +1,224 @@
+package grpcx
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/realcoke/ptest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	checkMethod = "/grpc.health.v1.Health/Check"
+	watchMethod = "/grpc.health.v1.Health/Watch"
+)
+
+// start serves the health service over an in-memory connection, with the
+// reporter's interceptors on both sides. stop ends every call and waits for
+// the server to finish them.
+func start(t *testing.T, reporter *Reporter) (client healthpb.HealthClient, stop func()) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(reporter.ServerOptions()...)
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("shop", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(listener)
+
+	options := append(reporter.DialOptions(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufconn", options...)
+	if err != nil {
+		t.Fatalf("NewClient() = %v", err)
+	}
+
+	return healthpb.NewHealthClient(conn), func() {
+		conn.Close()
+		server.GracefulStop()
+	}
+}
+
+// newRunner returns a runner that registers its handlers on an unused mux
+func newRunner(t *testing.T) *ptest.TestRunner {
+	t.Helper()
+
+	runner := ptest.NewTestRunnerWithHandler(http.NewServeMux())
+	t.Cleanup(func() { runner.Close() })
+	return runner
+}
+
+// report is the cumulative outcome of one label set
+type report struct {
+	requests int64
+	failed   bool
+}
+
+// reports waits until a session's label sets hold the given number of
+// requests and returns them by method, code and side
+func reports(t *testing.T, session *ptest.TestSession, requests int64) map[[3]string]report {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		result := make(map[[3]string]report)
+		var total int64
+		for _, stats := range session.GetLabelStats() {
+			key := [3]string{stats.Labels[MethodLabel], stats.Labels[CodeLabel], stats.Labels[SideLabel]}
+			result[key] = report{requests: stats.TotalRequests, failed: stats.CumulativeErrorRate > 0}
+			total += stats.TotalRequests
+		}
+		if total >= requests {
+			return result
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("label sets hold %d requests, want %d: %v", total, requests, result)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// watchOnce receives the first status of a watch and cancels it
+func watchOnce(t *testing.T, client healthpb.HealthClient) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "shop"})
+	if err != nil {
+		t.Fatalf("Watch() = %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("Recv() = %v", err)
+	}
+	cancel()
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return
+		}
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	acceptNotFound := func(code codes.Code) bool { return code == codes.OK || code == codes.NotFound }
+
+	tests := []struct {
+		name    string
+		success SuccessPolicy
+		call    func(t *testing.T, client healthpb.HealthClient)
+		want    map[[3]string]report
+	}{
+		{"unary", nil, func(t *testing.T, client healthpb.HealthClient) {
+			if _, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "shop"}); err != nil {
+				t.Fatalf("Check() = %v", err)
+			}
+		}, map[[3]string]report{
+			{checkMethod, "OK", SideClient}: {1, false},
+			{checkMethod, "OK", SideServer}: {1, false},
+		}},
+		{"unary failure", nil, func(t *testing.T, client healthpb.HealthClient) {
+			client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "cart"})
+		}, map[[3]string]report{
+			{checkMethod, "NotFound", SideClient}: {1, true},
+			{checkMethod, "NotFound", SideServer}: {1, true},
+		}},
+		{"unary success policy", acceptNotFound, func(t *testing.T, client healthpb.HealthClient) {
+			client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "cart"})
+		}, map[[3]string]report{
+			{checkMethod, "NotFound", SideClient}: {1, false},
+			{checkMethod, "NotFound", SideServer}: {1, false},
+		}},
+		{"server streaming", nil, watchOnce, map[[3]string]report{
+			{watchMethod, "Canceled", SideClient}: {1, true},
+			{watchMethod, "Canceled", SideServer}: {1, true},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := newRunner(t)
+			session := runner.StartTest(t.Name())
+			client, stop := start(t, New(runner, Config{Success: tt.success}))
+
+			tt.call(t, client)
+			stop()
+			runner.StopTest()
+
+			got := reports(t, session, int64(len(tt.want)))
+			if len(got) != len(tt.want) {
+				t.Errorf("got label sets %v, want %v", got, tt.want)
+			}
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%v reported %+v, want %+v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestInterceptorsInLoadDriver(t *testing.T) {
+	const calls = 3
+
+	tests := []struct {
+		name       string
+		success    SuccessPolicy
+		wantFailed bool
+	}{
+		{"default policy", nil, true},
+		{"policy accepting the code", func(code codes.Code) bool { return code == codes.NotFound }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := newRunner(t)
+			session := runner.StartTest(t.Name())
+			client, stop := start(t, New(runner, Config{Success: tt.success}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// The call after the last one stops the driver
+			var started atomic.Int64
+			config := ptest.ClosedConfig{Users: 1, SelfReported: true}
+			session.RunClosedWithConfig(ctx, config, func(ctx context.Context, vu *ptest.VU) error {
+				if started.Add(1) > calls {
+					cancel()
+					return nil
+				}
+				_, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: "cart"})
+				return err
+			})
+			stop()
+			runner.StopTest()
+
+			got := reports(t, session, 2*calls)
+			want := map[[3]string]report{
+				// Every call is reported by the interceptors on both sides
+				{checkMethod, "NotFound", SideClient}: {calls, tt.wantFailed},
+				{checkMethod, "NotFound", SideServer}: {calls, tt.wantFailed},
+			}
+			if len(got) != len(want) {
+				t.Errorf("got label sets %v, want %v", got, want)
+			}
+			if total := session.GetStats().TotalRequests; total != 2*calls {
+				t.Errorf("session counts %d requests, want %d", total, 2*calls)
+			}
+			for key, w := range want {
+				if got[key] != w {
+					t.Errorf("%v reported %+v, want %+v", key, got[key], w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package ptest
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// DefaultHistogramPrecision is the number of significant decimal digits
+// latency histograms keep when none is configured
+const DefaultHistogramPrecision = 2
+
+// Histogram is a mergeable log-linear histogram of durations.
+//
+// Values are grouped by power of two, and each power of two is split into
+// equally wide sub-buckets, so the relative error of any reported value is
+// bounded by the precision no matter how large the value is. Memory only
+// depends on the precision and the range of recorded values, never on how
+// many values were recorded.
+type Histogram struct {
+	// subBits is log2 of the number of sub-buckets per power of two
+	subBits int
+	// counts holds one lazily allocated slice of sub-buckets per power of two
+	counts [][]int64
+
+	count int64
+	sum   float64
+	sumSq float64
+	min   time.Duration
+	max   time.Duration
+}
+
+// NewHistogram creates a histogram that keeps precision significant decimal
+// digits (1 to 5) of every recorded duration
+func NewHistogram(precision int) *Histogram {
+	if precision < 1 {
+		precision = 1
+	} else if precision > 5 {
+		precision = 5
+	}
+
+	subBits := int(math.Ceil(math.Log2(math.Pow10(precision))))
+	return &Histogram{
+		subBits: subBits,
+		counts:  make([][]int64, 65-subBits),
+	}
+}
+
+// newEmptyLike creates an empty histogram with the same precision
+func (h *Histogram) newEmptyLike() *Histogram {
+	return &Histogram{
+		subBits: h.subBits,
+		counts:  make([][]int64, len(h.counts)),
+	}
+}
+
+// index returns the power of two group and sub-bucket of a value.
+// Group 0 holds the values below 2^subBits exactly; group g > 0 holds
+// [2^(subBits+g-1), 2^(subBits+g)) in sub-buckets 2^(g-1) wide.
+func (h *Histogram) index(value int64) (int, int) {
+	if value < 1<<h.subBits {
+		return 0, int(value)
+	}
+
+	group := bits.Len64(uint64(value)) - h.subBits
+	return group, int(value>>(group-1)) - 1<<h.subBits
+}
+
+// bucketRange returns the lowest value and the width of a sub-bucket
+func (h *Histogram) bucketRange(group, sub int) (int64, int64) {
+	if group == 0 {
+		return int64(sub), 1
+	}
+	return int64(sub+1<<h.subBits) << (group - 1), 1 << (group - 1)
+}
+
+// Record adds a duration to the histogram
+func (h *Histogram) Record(d time.Duration) {
+	h.RecordN(d, 1)
+}
+
+// RecordN adds a duration n times to the histogram
+func (h *Histogram) RecordN(d time.Duration, n int64) {
+	if n <= 0 {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	group, sub := h.index(int64(d))
+	if h.counts[group] == nil {
+		h.counts[group] = make([]int64, 1<<h.subBits)
+	}
+	h.counts[group][sub] += n
+
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.count += n
+	h.sum += float64(d) * float64(n)
+	h.sumSq += float64(d) * float64(d) * float64(n)
+}
+
+// Merge adds all values of another histogram
+func (h *Histogram) Merge(other *Histogram) {
+	h.mergeWeighted(other, 1)
+}
+
+// mergeWeighted adds all values of another histogram, each counted weight
+// times, e.g. the trips of a sampled interval
+func (h *Histogram) mergeWeighted(other *Histogram, weight int64) {
+	if other == nil || other.count == 0 {
+		return
+	}
+	if weight < 1 {
+		weight = 1
+	}
+
+	if other.subBits != h.subBits {
+		// Different precision, re-record the other histogram's bucket midpoints
+		// and keep its exact count, sum and extremes
+		low, high, count, sum, sumSq := h.min, h.max, h.count, h.sum, h.sumSq
+		other.forEach(func(low, width, n int64) {
+			h.RecordN(time.Duration(low+width/2), n*weight)
+		})
+		h.min, h.max, h.count, h.sum, h.sumSq = low, high, count, sum, sumSq
+	} else {
+		for group, counts := range other.counts {
+			if counts == nil {
+				continue
+			}
+			if h.counts[group] == nil {
+				h.counts[group] = make([]int64, len(counts))
+			}
+			for sub, n := range counts {
+				h.counts[group][sub] += n * weight
+			}
+		}
+	}
+
+	if h.count == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+	h.count += other.count * weight
+	h.sum += other.sum * float64(weight)
+	h.sumSq += other.sumSq * float64(weight)
+}
+
+// forEach calls fn for every non-empty sub-bucket in ascending order
+func (h *Histogram) forEach(fn func(low, width, count int64)) {
+	for group, counts := range h.counts {
+		for sub, count := range counts {
+			if count > 0 {
+				low, width := h.bucketRange(group, sub)
+				fn(low, width, count)
+			}
+		}
+	}
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.count
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() time.Duration {
+	return h.min
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the exact mean of the recorded values
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.count))
+}
+
+// StdDev returns the exact population standard deviation of the recorded values
+func (h *Histogram) StdDev() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+
+	mean := h.sum / float64(h.count)
+	variance := h.sumSq/float64(h.count) - mean*mean
+	if variance <= 0 {
+		return 0
+	}
+	return time.Duration(math.Sqrt(variance))
+}
+
+// Percentile returns the value below which the given percentage (0 to 100)
+// of recorded values fall, accurate to the histogram's precision
+func (h *Histogram) Percentile(percentile float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	if percentile <= 0 {
+		return h.min
+	}
+	if percentile >= 100 {
+		return h.max
+	}
+
+	rank := int64(math.Ceil(percentile / 100 * float64(h.count)))
+	var seen int64
+	for group, counts := range h.counts {
+		for sub, count := range counts {
+			seen += count
+			if seen >= rank {
+				low, width := h.bucketRange(group, sub)
+				return h.clamp(time.Duration(low + width/2))
+			}
+		}
+	}
+	return h.max
+}
+
+// clamp keeps an estimated value within the recorded range
+func (h *Histogram) clamp(d time.Duration) time.Duration {
+	if d < h.min {
+		return h.min
+	}
+	if d > h.max {
+		return h.max
+	}
+	return d
+}
+
+// LatencySummary summarizes a latency distribution; times are in milliseconds
+type LatencySummary struct {
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	// Percentiles holds the requested percentiles, keyed like Stat.Percentiles
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+// Summary returns the summary of the recorded values with the given
+// percentiles, or nil if there are none
+func (h *Histogram) Summary(percentiles []float64) *LatencySummary {
+	if h == nil || h.count == 0 {
+		return nil
+	}
+
+	return &LatencySummary{
+		Count:       h.count,
+		Min:         durationToMillis(h.min),
+		Max:         durationToMillis(h.max),
+		Mean:        durationToMillis(h.Mean()),
+		StdDev:      durationToMillis(h.StdDev()),
+		Percentiles: percentileMap(h, percentiles),
+	}
+}
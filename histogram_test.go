@@ -0,0 +1,83 @@
+package ptest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramSummaryPercentiles(t *testing.T) {
+	h := NewHistogram(3)
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		name        string
+		percentiles []float64
+		want        []string
+	}{
+		{"defaults", DefaultPercentiles, []string{"p50", "p90", "p95", "p99", "p99.9"}},
+		{"custom", []float64{75, 99.99}, []string{"p75", "p99.99"}},
+		{"none", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary := h.Summary(tt.percentiles)
+
+			var keys []string
+			for _, percentile := range tt.percentiles {
+				key := percentileKey(percentile)
+				keys = append(keys, key)
+
+				want := float64(percentile) * 10
+				if got := summary.Percentiles[key]; got < want*0.99 || got > want*1.01+1 {
+					t.Errorf("%s = %.2fms, want about %.2fms", key, got, want)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys %v, want %v", keys, tt.want)
+			}
+			if len(summary.Percentiles) != len(tt.want) {
+				t.Errorf("summary has %d percentiles, want %d", len(summary.Percentiles), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestHistogramMergeWeighted(t *testing.T) {
+	tests := []struct {
+		name          string
+		precision     int
+		weight        int64
+		wantCount     int64
+		wantMeanMilli float64
+	}{
+		{"same precision", DefaultHistogramPrecision, 10, 30, 2},
+		{"other precision", 4, 10, 30, 2},
+		{"zero weight counts once", DefaultHistogramPrecision, 0, 3, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := NewHistogram(tt.precision)
+			for _, ms := range []int{1, 2, 3} {
+				src.Record(time.Duration(ms) * time.Millisecond)
+			}
+
+			dst := NewHistogram(DefaultHistogramPrecision)
+			dst.mergeWeighted(src, tt.weight)
+
+			if dst.Count() != tt.wantCount {
+				t.Errorf("count %d, want %d", dst.Count(), tt.wantCount)
+			}
+			if got := durationToMillis(dst.Mean()); got != tt.wantMeanMilli {
+				t.Errorf("mean %.3fms, want %.3fms", got, tt.wantMeanMilli)
+			}
+			if dst.Min() != time.Millisecond || dst.Max() != 3*time.Millisecond {
+				t.Errorf("range %v to %v, want 1ms to 3ms", dst.Min(), dst.Max())
+			}
+		})
+	}
+}
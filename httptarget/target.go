@@ -0,0 +1,229 @@
+// Package httptarget sends HTTP requests built from templates and reports
+// them to the current test session of a ptest.TestRunner.
+//
+// A Target times every request, drains and counts the response body, decides
+// success by status code and records the status code distribution and the
+// response sizes with the labeled statistics of every request:
+//
+//	target := httptarget.New(runner, httptarget.Config{},
+//		httptarget.Request{Name: "home", URL: "http://localhost:8080/"},
+//		httptarget.Request{Name: "search", URL: "http://localhost:8080/search?q=go"},
+//	)
+//	runner.RunOpen(ctx, ptest.OpenConfig{Rate: 100}, target.Run)
+package httptarget
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/realcoke/ptest"
+)
+
+const (
+	// DefaultMaxConns is the connection limit per host of the default client
+	DefaultMaxConns = 1000
+	// DefaultTimeout is the request timeout of the default client
+	DefaultTimeout = 30 * time.Second
+)
+
+// DefaultSuccess counts 2xx and 3xx responses as successes
+var DefaultSuccess = []StatusRange{{Min: 200, Max: 399}}
+
+// StatusRange is an inclusive range of status codes
+type StatusRange struct {
+	Min int
+	Max int
+}
+
+// Contains reports whether a status code is in the range
+func (r StatusRange) Contains(code int) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// Request is a template of the requests a Target sends
+type Request struct {
+	// Name labels the reports of the request with ptest.OperationLabel
+	Name string
+	// Method is GET when empty
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Labels are added to the reports of the request
+	Labels map[string]string
+}
+
+// labels returns the label set reported with the request
+func (r *Request) labels() map[string]string {
+	labels := make(map[string]string, len(r.Labels)+1)
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	if r.Name != "" {
+		labels[ptest.OperationLabel] = r.Name
+	}
+	return labels
+}
+
+// build creates an HTTP request from the template
+func (r *Request) build(ctx context.Context) (*http.Request, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	if r.Header != nil {
+		req.Header = r.Header.Clone()
+	}
+	return req, nil
+}
+
+// Config configures a Target
+type Config struct {
+	// Client sends the requests; by default a client from NewClient
+	Client *http.Client
+	// MaxConns is the connection limit per host of the default client,
+	// DefaultMaxConns when zero
+	MaxConns int
+	// Timeout bounds every request of the default client, DefaultTimeout when zero
+	Timeout time.Duration
+	// Success lists the status codes counted as successes, DefaultSuccess when empty
+	Success []StatusRange
+}
+
+// withDefaults fills in unset fields
+func (c Config) withDefaults() Config {
+	if c.MaxConns <= 0 {
+		c.MaxConns = DefaultMaxConns
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	if c.Client == nil {
+		c.Client = NewClient(c.MaxConns, c.Timeout)
+	}
+	if len(c.Success) == 0 {
+		c.Success = DefaultSuccess
+	}
+	return c
+}
+
+// NewClient returns a client tuned for load tests: it keeps up to maxConns
+// connections per host alive for reuse instead of the standard library's two
+func NewClient(maxConns int, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = maxConns
+	transport.MaxConnsPerHost = maxConns
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// Target sends requests from templates and reports them to the current
+// session of a runner. It is safe for concurrent use.
+type Target struct {
+	runner   *ptest.TestRunner
+	client   *http.Client
+	success  []StatusRange
+	requests []Request
+	next     atomic.Uint64
+}
+
+// New creates a target sending the given request templates
+func New(runner *ptest.TestRunner, config Config, requests ...Request) *Target {
+	config = config.withDefaults()
+
+	return &Target{
+		runner:   runner,
+		client:   config.Client,
+		success:  config.Success,
+		requests: requests,
+	}
+}
+
+// Run sends the next request template in turn. It fits RunOpen and
+// RunOpenProfile with a SelfReported configuration.
+func (t *Target) Run(ctx context.Context) error {
+	if len(t.requests) == 0 {
+		return nil
+	}
+
+	index := (t.next.Add(1) - 1) % uint64(len(t.requests))
+	return t.Do(ctx, &t.requests[index])
+}
+
+// RunVU sends the request template of the virtual user's iteration, so every
+// virtual user walks through the templates in order. It fits
+// RunClosedWithConfig and RunClosedProfile with a SelfReported configuration.
+func (t *Target) RunVU(ctx context.Context, vu *ptest.VU) error {
+	if len(t.requests) == 0 {
+		return nil
+	}
+
+	return t.Do(ctx, &t.requests[vu.Iteration%int64(len(t.requests))])
+}
+
+// Do sends one request built from a template and reports it with its HTTP
+// phase timings. Load drivers running Do should be configured SelfReported,
+// so the call is not counted a second time. A status code outside the
+// success ranges is returned as a *ptest.StatusError.
+func (t *Target) Do(ctx context.Context, request *Request) error {
+	tok := t.runner.BeginWithLabels(request.labels())
+	err := t.send(ctx, request, tok)
+	tok.EndError(err)
+	return err
+}
+
+// send sends a request and drains its response; its phases, status code and
+// size are reported with tok
+func (t *Target) send(ctx context.Context, request *Request, tok *ptest.Token) error {
+	req, err := request.build(ctx)
+	if err != nil {
+		return err
+	}
+
+	req, finish := ptest.TraceHTTP(req, tok)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	// Reading the whole body lets the connection be reused
+	size, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	finish(resp.StatusCode, size)
+
+	if err != nil {
+		return err
+	}
+	if !t.successful(resp.StatusCode) {
+		return ptest.NewStatusError(resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return nil
+}
+
+// successful reports whether a status code is in one of the success ranges
+func (t *Target) successful(code int) bool {
+	for _, r := range t.success {
+		if r.Contains(code) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package httptarget
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/realcoke/ptest"
+)
+
+// received is a request as the test server saw it
+type received struct {
+	method string
+	uri    string
+	header string
+	body   string
+}
+
+// newServer starts a server that records the requests it receives and
+// answers with the status code and body size given by the query parameters
+// "status" and "size"
+func newServer(t *testing.T) (*httptest.Server, func() []received) {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var requests []received
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mutex.Lock()
+		requests = append(requests, received{
+			method: r.Method,
+			uri:    r.URL.RequestURI(),
+			header: r.Header.Get("X-Test"),
+			body:   string(body),
+		})
+		mutex.Unlock()
+
+		if status, err := strconv.Atoi(r.URL.Query().Get("status")); err == nil {
+			w.WriteHeader(status)
+		}
+		if size, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil {
+			w.Write([]byte(strings.Repeat("x", size)))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received(nil), requests...)
+	}
+}
+
+// newRunner returns a runner that registers its handlers on an unused mux
+func newRunner(t *testing.T) *ptest.TestRunner {
+	t.Helper()
+
+	runner := ptest.NewTestRunnerWithHandler(http.NewServeMux())
+	t.Cleanup(func() { runner.Close() })
+	return runner
+}
+
+// labelStats waits until a session's label sets hold the given number of
+// requests and returns them by request name
+func labelStats(t *testing.T, session *ptest.TestSession, requests int64) map[string]*ptest.LabelStats {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		byName := make(map[string]*ptest.LabelStats)
+		var total int64
+		for _, stats := range session.GetLabelStats() {
+			byName[stats.Labels[ptest.OperationLabel]] = stats
+			total += stats.TotalRequests
+		}
+		if total >= requests {
+			return byName
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("label sets hold %d requests, want %d", total, requests)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRequestTemplates(t *testing.T) {
+	server, requests := newServer(t)
+
+	tests := []struct {
+		name    string
+		request Request
+		want    received
+	}{
+		{"get by default", Request{URL: server.URL + "/home?q=go"}, received{method: http.MethodGet, uri: "/home?q=go"}},
+		{"method and body", Request{Method: http.MethodPost, URL: server.URL + "/orders", Body: []byte(`{"item":1}`)},
+			received{method: http.MethodPost, uri: "/orders", body: `{"item":1}`}},
+		{"header", Request{URL: server.URL + "/", Header: http.Header{"X-Test": {"yes"}}}, received{method: http.MethodGet, uri: "/", header: "yes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := New(newRunner(t), Config{})
+
+			// The template is reused, so send it twice
+			for i := 0; i < 2; i++ {
+				if err := target.Do(context.Background(), &tt.request); err != nil {
+					t.Fatalf("Do() = %v", err)
+				}
+			}
+
+			got := requests()
+			got = got[len(got)-2:]
+			for _, r := range got {
+				if r != tt.want {
+					t.Errorf("server received %+v, want %+v", r, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestStatusClassification(t *testing.T) {
+	server, _ := newServer(t)
+
+	tests := []struct {
+		name    string
+		success []StatusRange
+		status  int
+		wantErr bool
+	}{
+		{"ok", nil, http.StatusOK, false},
+		{"redirect", nil, http.StatusNotModified, false},
+		{"not found", nil, http.StatusNotFound, true},
+		{"server error", nil, http.StatusServiceUnavailable, true},
+		{"custom success", []StatusRange{{Min: 200, Max: 299}, {Min: 404, Max: 404}}, http.StatusNotFound, false},
+		{"outside custom success", []StatusRange{{Min: 200, Max: 299}}, http.StatusNotModified, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := New(newRunner(t), Config{Success: tt.success})
+			request := Request{URL: server.URL + "/?status=" + strconv.Itoa(tt.status)}
+
+			err := target.Do(context.Background(), &request)
+			var statusErr *ptest.StatusError
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("Do() = %v, want success", err)
+				}
+				return
+			}
+			if !errors.As(err, &statusErr) || statusErr.Code != tt.status {
+				t.Errorf("Do() = %v, want a StatusError with code %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestLabeledResponses(t *testing.T) {
+	server, _ := newServer(t)
+
+	const rounds = 3
+	requests := []Request{
+		{Name: "ok", URL: server.URL + "/?size=100"},
+		{Name: "missing", URL: server.URL + "/?status=404&size=10"},
+	}
+
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, session *ptest.TestSession, target *Target)
+	}{
+		{"on their own", func(ctx context.Context, session *ptest.TestSession, target *Target) {
+			for i := 0; i < rounds*len(requests); i++ {
+				target.Run(ctx)
+			}
+		}},
+		{"in a load driver", func(ctx context.Context, session *ptest.TestSession, target *Target) {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			// The call after the last one stops the driver
+			var calls atomic.Int64
+			config := ptest.ClosedConfig{Users: 1, SelfReported: true}
+			session.RunClosedWithConfig(ctx, config, func(ctx context.Context, vu *ptest.VU) error {
+				if calls.Add(1) > rounds*int64(len(requests)) {
+					cancel()
+					return nil
+				}
+				return target.RunVU(ctx, vu)
+			})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := newRunner(t)
+			session := runner.StartTest(t.Name())
+			target := New(runner, Config{}, requests...)
+
+			tt.run(context.Background(), session, target)
+			runner.StopTest()
+
+			stats := labelStats(t, session, rounds*int64(len(requests)))
+			if total := session.GetStats().TotalRequests; total != rounds*int64(len(requests)) {
+				t.Errorf("session counts %d requests, want every request once", total)
+			}
+
+			want := map[string]struct {
+				status int
+				size   int64
+			}{
+				"ok":      {http.StatusOK, 100},
+				"missing": {http.StatusNotFound, 10},
+			}
+			for name, w := range want {
+				responses := stats[name].Responses
+				if responses == nil {
+					t.Fatalf("no responses recorded for %q", name)
+				}
+				if got := responses.StatusCodes[w.status]; got != rounds || len(responses.StatusCodes) != 1 {
+					t.Errorf("%q status codes %v, want %d x %d", name, responses.StatusCodes, rounds, w.status)
+				}
+				if responses.TotalBytes != rounds*w.size || responses.MaxBytes != w.size {
+					t.Errorf("%q response bytes total %d max %d, want %d and %d", name, responses.TotalBytes, responses.MaxBytes, rounds*w.size, w.size)
+				}
+			}
+			if errorRate := stats["missing"].CumulativeErrorRate; errorRate != 100 {
+				t.Errorf("error rate of 404s is %v%%, want 100%%", errorRate)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package ptest
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxLabelSets limits how many distinct label sets get their own statistics.
+// Trips with label sets beyond the limit are still counted in the overall
+// stats; the first one logs a warning and all are counted in DropStats.
+const maxLabelSets = 256
+
+// OperationLabel is the label key used by Operation
+const OperationLabel = "operation"
+
+// labelEscaper escapes the separators of label keys
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`)
+
+// escapeLabel escapes the separators in a label key or value, so that
+// distinct label sets never share a key
+func escapeLabel(s string) string {
+	if !strings.ContainsAny(s, `\,=`) {
+		return s
+	}
+	return labelEscaper.Replace(s)
+}
+
+// labelKey returns a canonical key for a label set: its pairs sorted by key
+// as k=v, separated by commas, with backslash, comma and equals sign escaped
+// by a backslash
+func labelKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	// Single labels such as Operation need no sorting
+	if len(labels) == 1 {
+		for k, v := range labels {
+			return escapeLabel(k) + "=" + escapeLabel(v)
+		}
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(escapeLabel(k))
+		sb.WriteByte('=')
+		sb.WriteString(escapeLabel(labels[k]))
+	}
+	return sb.String()
+}
+
+// copyLabels returns a copy of a label set so callers can reuse their map
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
+}
+
+// Operation reports results of one named operation to a TestRunner
+type Operation struct {
+	runner *TestRunner
+	labels map[string]string
+}
+
+// Operation returns a handle that reports results labeled with the operation name
+func (tr *TestRunner) Operation(name string) *Operation {
+	return &Operation{
+		runner: tr,
+		labels: map[string]string{OperationLabel: name},
+	}
+}
+
+// With returns a copy of the operation with an additional label
+func (op *Operation) With(key, value string) *Operation {
+	labels := copyLabels(op.labels)
+	labels[key] = value
+
+	return &Operation{
+		runner: op.runner,
+		labels: labels,
+	}
+}
+
+// Report reports a result of the operation that finished now
+func (op *Operation) Report(start time.Time, success bool) {
+	op.runner.ReportWithLabels(start, success, op.labels)
+}
+
+// ReportDuration reports a result of the operation with an explicitly measured response time
+func (op *Operation) ReportDuration(start time.Time, elapsed time.Duration, success bool) {
+	op.runner.ReportTrip(&Trip{
+		StartTime: start,
+		EndTime:   start.Add(elapsed),
+		Success:   success,
+		Labels:    op.labels,
+	})
+}
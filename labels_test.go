@@ -0,0 +1,82 @@
+package ptest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLabelKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"empty", nil, ""},
+		{"single", map[string]string{"operation": "search"}, "operation=search"},
+		{"sorted", map[string]string{"b": "2", "a": "1"}, "a=1,b=2"},
+		{"comma in value", map[string]string{"a": "1,b=2"}, `a=1\,b\=2`},
+		{"equals in key", map[string]string{"a=b": "c"}, `a\=b=c`},
+		{"backslash", map[string]string{"a": `x\`, "b": "y"}, `a=x\\,b=y`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelKey(tt.labels); got != tt.want {
+				t.Errorf("labelKey(%v) = %q, want %q", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelKeyDistinct(t *testing.T) {
+	// Label sets that joined without escaping would collide
+	sets := []map[string]string{
+		{"a": "1,b=2"},
+		{"a": "1", "b": "2"},
+		{"a": `1\`, "b": "2"},
+		{"a": "1=", "b": "2"},
+	}
+
+	seen := make(map[string]map[string]string)
+	for _, labels := range sets {
+		key := labelKey(labels)
+		if other, ok := seen[key]; ok {
+			t.Errorf("%v and %v share the key %q", labels, other, key)
+		}
+		seen[key] = labels
+	}
+}
+
+func TestLabelSetLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		sets        int
+		wantLabeled int
+		wantDropped int64
+	}{
+		{"under the limit", 10, 10, 0},
+		{"at the limit", maxLabelSets, maxLabelSets, 0},
+		{"over the limit", maxLabelSets + 5, maxLabelSets, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+			for i := 0; i < tt.sets; i++ {
+				dc.ReportWithLabels(time.Now(), true, map[string]string{"id": fmt.Sprint(i)})
+			}
+			trips := collect(t, dc)
+
+			if got := trips.count(); got != int64(tt.sets) {
+				t.Errorf("counted %d trips overall, want %d", got, tt.sets)
+			}
+			if got := len(trips.Labeled); got != tt.wantLabeled {
+				t.Errorf("got %d label sets, want %d", got, tt.wantLabeled)
+			}
+			if got := dc.GetDropStats().DroppedLabelTrips; got != tt.wantDropped {
+				t.Errorf("DroppedLabelTrips = %d, want %d", got, tt.wantDropped)
+			}
+		})
+	}
+}
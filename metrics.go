@@ -0,0 +1,409 @@
+package ptest
+
+import (
+	"log"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+// MetricKind is the kind of a custom metric
+type MetricKind string
+
+const (
+	// MetricCounter sums the values added during each interval
+	MetricCounter MetricKind = "counter"
+	// MetricGauge keeps the last value set; it carries over to intervals
+	// in which it is not set
+	MetricGauge MetricKind = "gauge"
+	// MetricHistogram keeps the distribution of the values observed during
+	// each interval
+	MetricHistogram MetricKind = "histogram"
+)
+
+// maxMetrics limits how many custom metrics a session keeps. Values of
+// metrics beyond the limit, or of a name reused with another kind, are
+// dropped; the first one logs a warning and all are counted in DropStats.
+const maxMetrics = 64
+
+// metricScale is how many histogram units one metric value spans, so value
+// histograms keep three decimals of fractional values
+const metricScale = 1000
+
+// metricSink receives the values of custom metrics
+type metricSink interface {
+	recordMetric(name string, kind MetricKind, value float64)
+}
+
+// Counter is a custom metric whose values are summed per interval, e.g.
+// bytes transferred or retries
+type Counter struct {
+	sink metricSink
+	name string
+}
+
+// Add adds delta to the counter
+func (c *Counter) Add(delta float64) {
+	c.sink.recordMetric(c.name, MetricCounter, delta)
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Gauge is a custom metric that holds a current value, e.g. queue depth or
+// cache hit ratio
+type Gauge struct {
+	sink metricSink
+	name string
+}
+
+// Set sets the gauge's current value
+func (g *Gauge) Set(value float64) {
+	g.sink.recordMetric(g.name, MetricGauge, value)
+}
+
+// ValueHistogram is a custom metric that keeps the distribution of observed
+// values, e.g. payload sizes. Values must not be negative.
+type ValueHistogram struct {
+	sink metricSink
+	name string
+}
+
+// Observe adds a value to the histogram
+func (h *ValueHistogram) Observe(value float64) {
+	h.sink.recordMetric(h.name, MetricHistogram, value)
+}
+
+// metricBucket holds the values of one custom metric in one interval
+type metricBucket struct {
+	kind MetricKind
+
+	// sum is the counter total
+	sum float64
+	// last is the gauge value set at lastAt, in Unix nanoseconds
+	last   float64
+	lastAt int64
+	// values holds observed values in thousandths
+	values *Histogram
+}
+
+// newMetricBucket creates an empty metric bucket
+func newMetricBucket(kind MetricKind, precision int) *metricBucket {
+	m := &metricBucket{kind: kind}
+	if kind == MetricHistogram {
+		m.values = NewHistogram(precision)
+	}
+	return m
+}
+
+// add adds a value recorded at the given Unix nanoseconds
+func (m *metricBucket) add(value float64, at int64) {
+	switch m.kind {
+	case MetricCounter:
+		m.sum += value
+	case MetricGauge:
+		if at >= m.lastAt {
+			m.last = value
+			m.lastAt = at
+		}
+	case MetricHistogram:
+		m.values.Record(time.Duration(value * metricScale))
+	}
+}
+
+// merge adds the values of the same metric in another shard
+func (m *metricBucket) merge(other *metricBucket) {
+	m.sum += other.sum
+	if other.lastAt >= m.lastAt {
+		m.last = other.last
+		m.lastAt = other.lastAt
+	}
+	if m.values != nil {
+		m.values.Merge(other.values)
+	}
+}
+
+// MetricStat holds the aggregated values of one custom metric in a period
+type MetricStat struct {
+	Kind MetricKind `json:"kind"`
+	// Value is the counter total, the last gauge value or the histogram mean
+	Value float64 `json:"value"`
+	// Rate is the counter total per second
+	Rate float64 `json:"rate,omitempty"`
+
+	// Count, Min, Max and the percentiles describe histogram values
+	Count int64   `json:"count,omitempty"`
+	Min   float64 `json:"min,omitempty"`
+	Max   float64 `json:"max,omitempty"`
+	P50   float64 `json:"p50,omitempty"`
+	P90   float64 `json:"p90,omitempty"`
+	P99   float64 `json:"p99,omitempty"`
+
+	// Histogram holds histogram values in thousandths so periods can be merged
+	Histogram *Histogram `json:"-"`
+}
+
+// newMetricStat calculates the stat of a metric over a period of the given seconds
+func newMetricStat(m *metricBucket, seconds float64) *MetricStat {
+	stat := &MetricStat{Kind: m.kind}
+
+	switch m.kind {
+	case MetricCounter:
+		stat.Value = m.sum
+		if seconds > 0 {
+			stat.Rate = m.sum / seconds
+		}
+	case MetricGauge:
+		stat.Value = m.last
+	case MetricHistogram:
+		stat.Histogram = m.values
+		setMetricHistogramStats(stat)
+	}
+
+	return stat
+}
+
+// setMetricHistogramStats fills the value fields of a histogram metric stat
+func setMetricHistogramStats(stat *MetricStat) {
+	values := stat.Histogram
+	if values == nil || values.Count() == 0 {
+		return
+	}
+
+	stat.Count = values.Count()
+	stat.Value = metricValue(values.Mean())
+	stat.Min = metricValue(values.Min())
+	stat.Max = metricValue(values.Max())
+	stat.P50 = metricValue(values.Percentile(50))
+	stat.P90 = metricValue(values.Percentile(90))
+	stat.P99 = metricValue(values.Percentile(99))
+}
+
+// metricValue converts a histogram value back to a metric value
+func metricValue(d time.Duration) float64 {
+	return float64(d) / metricScale
+}
+
+// calculateMetrics calculates the stats of all metrics of a bucket
+func calculateMetrics(metrics map[string]*metricBucket, interval time.Duration) map[string]*MetricStat {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*MetricStat, len(metrics))
+	for name, m := range metrics {
+		result[name] = newMetricStat(m, interval.Seconds())
+	}
+	return result
+}
+
+// metricsWithoutHistograms returns a copy of metric stats that does not keep
+// the value histograms alive
+func metricsWithoutHistograms(metrics map[string]*MetricStat) map[string]*MetricStat {
+	if len(metrics) == 0 {
+		return metrics
+	}
+
+	result := make(map[string]*MetricStat, len(metrics))
+	for name, stat := range metrics {
+		copied := *stat
+		copied.Histogram = nil
+		result[name] = &copied
+	}
+	return result
+}
+
+// aggregateMetrics aggregates the metrics of one tier window: counters are
+// summed, gauges keep the last value and histograms are merged
+func aggregateMetrics(stats []*Stat, seconds float64) map[string]*MetricStat {
+	var result map[string]*MetricStat
+
+	for _, stat := range stats {
+		for name, metric := range stat.Metrics {
+			if result == nil {
+				result = make(map[string]*MetricStat)
+			}
+			aggregated, ok := result[name]
+			if !ok {
+				aggregated = &MetricStat{Kind: metric.Kind}
+				result[name] = aggregated
+			}
+
+			switch metric.Kind {
+			case MetricCounter:
+				aggregated.Value += metric.Value
+			case MetricGauge:
+				aggregated.Value = metric.Value
+			case MetricHistogram:
+				aggregated.Histogram = mergeHistogram(aggregated.Histogram, metric.Histogram)
+			}
+		}
+	}
+
+	for _, metric := range result {
+		switch metric.Kind {
+		case MetricCounter:
+			if seconds > 0 {
+				metric.Rate = metric.Value / seconds
+			}
+		case MetricHistogram:
+			setMetricHistogramStats(metric)
+		}
+	}
+
+	return result
+}
+
+// openMetric returns the bucket of a metric in an interval, or nil if the
+// metric limit has been reached or the name is used with another kind
+func (dc *DataCollector) openMetric(bucket *TripsOfSec, name string, kind MetricKind) *metricBucket {
+	if m, ok := bucket.metrics[name]; ok {
+		if m.kind != kind {
+			return nil
+		}
+		return m
+	}
+
+	dc.metricMutex.Lock()
+	registered, ok := dc.metricKinds[name]
+	if !ok {
+		if len(dc.metricKinds) >= maxMetrics {
+			dc.metricMutex.Unlock()
+			return nil
+		}
+		registered = kind
+		dc.metricKinds[name] = kind
+	}
+	dc.metricMutex.Unlock()
+
+	if registered != kind {
+		return nil
+	}
+
+	if bucket.metrics == nil {
+		bucket.metrics = make(map[string]*metricBucket)
+	}
+
+	m := newMetricBucket(kind, dc.config.Precision)
+	bucket.metrics[name] = m
+	return m
+}
+
+// recordMetric adds a custom metric value to the current interval
+func (dc *DataCollector) recordMetric(name string, kind MetricKind, value float64) {
+	if !dc.running.Load() {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	shard := dc.shards[rand.Uint64()&dc.shardMask]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucket := dc.openBucket(shard, now/int64(dc.interval))
+	m := dc.openMetric(bucket, name, kind)
+	if m == nil {
+		dc.dropMetricValue(name, kind)
+		return
+	}
+	m.add(value, now)
+}
+
+// dropMetricValue counts a value of a metric over the limit or of the wrong
+// kind, warning about the first one
+func (dc *DataCollector) dropMetricValue(name string, kind MetricKind) {
+	atomic.AddInt64(&dc.droppedMetricValues, 1)
+	dc.metricWarning.Do(func() {
+		log.Printf("ptest: dropping values of %s metric %q: more than %d metrics or the name is used by another kind", kind, name, maxMetrics)
+	})
+}
+
+// carryGauges fills in gauges that were not set during an interval with
+// their last value
+func (dc *DataCollector) carryGauges(bucket *TripsOfSec) {
+	for name, m := range bucket.metrics {
+		if m.kind == MetricGauge {
+			dc.lastGauges[name] = m.last
+		}
+	}
+
+	for name, last := range dc.lastGauges {
+		if _, ok := bucket.metrics[name]; ok {
+			continue
+		}
+		if bucket.metrics == nil {
+			bucket.metrics = make(map[string]*metricBucket)
+		}
+		bucket.metrics[name] = &metricBucket{kind: MetricGauge, last: last}
+	}
+}
+
+// Counter returns a custom counter of the collector
+func (dc *DataCollector) Counter(name string) *Counter {
+	return &Counter{sink: dc, name: name}
+}
+
+// Gauge returns a custom gauge of the collector
+func (dc *DataCollector) Gauge(name string) *Gauge {
+	return &Gauge{sink: dc, name: name}
+}
+
+// ValueHistogram returns a custom value histogram of the collector
+func (dc *DataCollector) ValueHistogram(name string) *ValueHistogram {
+	return &ValueHistogram{sink: dc, name: name}
+}
+
+// recordMetric adds a custom metric value to the session
+func (ts *TestSession) recordMetric(name string, kind MetricKind, value float64) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.recordMetric(name, kind, value)
+}
+
+// Counter returns a custom counter of the session, charted as a rate per second
+func (ts *TestSession) Counter(name string) *Counter {
+	return &Counter{sink: ts, name: name}
+}
+
+// Gauge returns a custom gauge of the session, charted as its value
+func (ts *TestSession) Gauge(name string) *Gauge {
+	return &Gauge{sink: ts, name: name}
+}
+
+// ValueHistogram returns a custom value histogram of the session, charted as
+// its mean and percentiles
+func (ts *TestSession) ValueHistogram(name string) *ValueHistogram {
+	return &ValueHistogram{sink: ts, name: name}
+}
+
+// recordMetric adds a custom metric value to the current session
+func (tr *TestRunner) recordMetric(name string, kind MetricKind, value float64) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil {
+		session.recordMetric(name, kind, value)
+	}
+}
+
+// Counter returns a custom counter that records to whichever session is current
+func (tr *TestRunner) Counter(name string) *Counter {
+	return &Counter{sink: tr, name: name}
+}
+
+// Gauge returns a custom gauge that records to whichever session is current
+func (tr *TestRunner) Gauge(name string) *Gauge {
+	return &Gauge{sink: tr, name: name}
+}
+
+// ValueHistogram returns a custom value histogram that records to whichever
+// session is current
+func (tr *TestRunner) ValueHistogram(name string) *ValueHistogram {
+	return &ValueHistogram{sink: tr, name: name}
+}
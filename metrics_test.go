@@ -0,0 +1,51 @@
+package ptest
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMetricLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		record      func(dc *DataCollector)
+		wantMetrics int
+		wantDropped int64
+	}{
+		{"under the limit", func(dc *DataCollector) {
+			for i := 0; i < 10; i++ {
+				dc.Counter(fmt.Sprint("counter_", i)).Inc()
+			}
+		}, 10, 0},
+		{"over the limit", func(dc *DataCollector) {
+			for i := 0; i < maxMetrics+3; i++ {
+				dc.Counter(fmt.Sprint("counter_", i)).Inc()
+			}
+			dc.Gauge(fmt.Sprint("counter_", maxMetrics+1)).Set(1)
+		}, maxMetrics, 4},
+		{"kind reused", func(dc *DataCollector) {
+			dc.Counter("requests").Inc()
+			dc.Gauge("requests").Set(5)
+			dc.ValueHistogram("requests").Observe(5)
+		}, 1, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := newDataCollector(DefaultCollectorConfig(), DefaultInterval)
+			tt.record(dc)
+			trips := collect(t, dc)
+
+			if got := len(trips.metrics); got != tt.wantMetrics {
+				t.Errorf("kept %d metrics, want %d", got, tt.wantMetrics)
+			}
+			drops := dc.GetDropStats()
+			if drops.DroppedMetricValues != tt.wantDropped {
+				t.Errorf("DroppedMetricValues = %d, want %d", drops.DroppedMetricValues, tt.wantDropped)
+			}
+			if drops.HasDrops() != (tt.wantDropped > 0) {
+				t.Errorf("HasDrops() = %v with %d dropped values", drops.HasDrops(), tt.wantDropped)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package ptest
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// StatusLabel is the label key holding the response status set by Middleware
+const StatusLabel = "status"
+
+// dashboardPrefix is the path prefix of the dashboard's own handlers
+const dashboardPrefix = "/ptest/"
+
+// Middleware wraps a handler so that every request it handles is reported to
+// the current session, labeled with its method, route and response status.
+// The route is the http.ServeMux pattern that matched the request, or the
+// DefaultRoute of the path for other routers. Responses with a 5xx status and
+// panics count as failures. Requests for the dashboard itself are not
+// reported, so the mux passed to NewTestRunnerWithHandler can be wrapped as a whole.
+func (tr *TestRunner) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, dashboardPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tok := tr.Begin()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		defer func() {
+			// A panicking handler fails the request; the panic goes on to the server
+			recovered := recover()
+			if recovered != nil {
+				rec.status = http.StatusInternalServerError
+			}
+
+			tok.labels = map[string]string{
+				MethodLabel: r.Method,
+				RouteLabel:  handlerRoute(r),
+				StatusLabel: strconv.Itoa(rec.status),
+			}
+
+			var err error
+			if rec.status >= 500 {
+				err = NewStatusError(rec.status, http.StatusText(rec.status))
+			}
+			tok.EndError(err)
+
+			if recovered != nil {
+				panic(recovered)
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// handlerRoute returns the route of a handled request: the path of the mux
+// pattern that matched it, without the method, or its DefaultRoute
+func handlerRoute(r *http.Request) string {
+	pattern := r.Pattern
+	if pattern == "" {
+		return DefaultRoute(r)
+	}
+
+	// Patterns look like "[METHOD ][HOST]/[PATH]"
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		pattern = strings.TrimLeft(pattern[i:], " \t")
+	}
+	return pattern
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+// WriteHeader implements http.ResponseWriter. Informational codes other
+// than 101 Switching Protocols precede the final status, so they are not
+// recorded.
+func (r *statusRecorder) WriteHeader(code int) {
+	informational := code >= 100 && code <= 199 && code != http.StatusSwitchingProtocols
+	if !r.wroteHeader && !informational {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// Write implements http.ResponseWriter
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher for handlers that stream
+func (r *statusRecorder) Flush() {
+	r.wroteHeader = true
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for handlers that take over the
+// connection, such as websocket upgrades. A connection taken over before a
+// status was written is recorded as 101 Switching Protocols.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && !r.wroteHeader {
+		r.status = http.StatusSwitchingProtocols
+		r.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives http.ResponseController access to the wrapped writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package ptest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		wantStatus string
+	}{
+		{"implicit ok", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }, "200"},
+		{"explicit status", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) }, "404"},
+		{"informational before final", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, "503"},
+		{"flushed stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("part"))
+			w.(http.Flusher).Flush()
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "200"},
+		{"hijacked", func(w http.ResponseWriter, r *http.Request) {
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack() = %v", err)
+				return
+			}
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+			rw.Flush()
+			conn.Close()
+		}, "101"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := NewTestRunnerWithHandler(http.NewServeMux())
+			defer runner.Close()
+			session := runner.StartTest(t.Name())
+
+			server := httptest.NewServer(runner.Middleware(tt.handler))
+			defer server.Close()
+
+			resp, err := http.Get(server.URL + "/stream")
+			if err != nil {
+				t.Fatalf("GET = %v", err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			server.Close()
+
+			runner.StopTest()
+			select {
+			case <-session.processed:
+			case <-time.After(5 * time.Second):
+				t.Fatal("session stats were not processed")
+			}
+
+			stats := session.GetLabelStats()
+			if len(stats) != 1 {
+				t.Fatalf("got %d label sets, want 1", len(stats))
+			}
+			if got := stats[0].Labels[StatusLabel]; got != tt.wantStatus {
+				t.Errorf("reported status %q, want %q", got, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package ptest
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ArrivalDistribution decides how the open-model executor spaces arrivals
+type ArrivalDistribution string
+
+const (
+	// ArrivalUniform spaces arrivals evenly
+	ArrivalUniform ArrivalDistribution = "uniform"
+	// ArrivalPoisson spaces arrivals with exponentially distributed gaps, like
+	// independent users arriving at random
+	ArrivalPoisson ArrivalDistribution = "poisson"
+)
+
+// DefaultMaxWorkers is the worker pool size of the open-model executor when none is configured
+const DefaultMaxWorkers = 1000
+
+// idleRateCheck is the longest the executor waits before checking the
+// target rate again, e.g. while it is zero or still low at the start of a ramp
+const idleRateCheck = 100 * time.Millisecond
+
+// OpenConfig configures the open-model executor
+type OpenConfig struct {
+	// Rate is the target number of iterations started per second
+	Rate float64
+	// Distribution spaces the arrivals, ArrivalUniform by default
+	Distribution ArrivalDistribution
+	// MaxWorkers bounds the number of iterations running at once; workers are
+	// started as arrivals need them. Arrivals that find every worker busy
+	// for a millisecond are counted as missed iterations instead of being
+	// delayed further.
+	MaxWorkers int
+	// SelfReported skips the driver's report of every call, for functions
+	// that report their requests themselves, e.g. httptarget's Target.Run or
+	// requests sent through a Transport. Arrivals are still scheduled and
+	// counted.
+	SelfReported bool
+}
+
+// withDefaults fills in unset fields
+func (c OpenConfig) withDefaults() OpenConfig {
+	if c.Distribution == "" {
+		c.Distribution = ArrivalUniform
+	}
+	if c.MaxWorkers <= 0 {
+		c.MaxWorkers = DefaultMaxWorkers
+	}
+	return c
+}
+
+// RunOpen runs an open-model load test against the current session: fn is
+// started at the target arrival rate no matter how long earlier calls take.
+// Every call is timed and reported with its scheduled start as intended start
+// time, so its latency is also corrected for coordinated omission, unless
+// config.SelfReported leaves the reports to fn. RunOpen
+// blocks until ctx is done or the session is stopped; the context passed to
+// fn is canceled then, and calls interrupted by the shutdown are not reported.
+func (tr *TestRunner) RunOpen(ctx context.Context, config OpenConfig, fn func(ctx context.Context) error) error {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return ErrNoSession
+	}
+	return session.RunOpen(ctx, config, fn)
+}
+
+// RunOpen runs an open-model load test against the session; see TestRunner.RunOpen
+func (ts *TestSession) RunOpen(ctx context.Context, config OpenConfig, fn func(ctx context.Context) error) error {
+	rate := config.Rate
+	return ts.runOpen(ctx, config, func(time.Duration) float64 { return rate }, fn)
+}
+
+// runOpen schedules arrivals at the rate returned for the time elapsed since
+// the start and hands them to a bounded pool of workers
+func (ts *TestSession) runOpen(ctx context.Context, config OpenConfig, rateAt func(elapsed time.Duration) float64, fn func(ctx context.Context) error) error {
+	if !ts.running() {
+		return ErrNoSession
+	}
+
+	config = config.withDefaults()
+	ctx, cancel := ts.runContext(ctx)
+	defer cancel()
+
+	pool := &openPool{
+		session:      ts,
+		fn:           fn,
+		selfReported: config.SelfReported,
+		arrivals:     make(chan time.Time),
+		maxWorkers:   config.MaxWorkers,
+	}
+	ts.scheduleArrivals(ctx, config.Distribution, rateAt, pool.dispatch)
+
+	pool.wg.Wait()
+	return nil
+}
+
+// scheduleArrivals hands arrival times to dispatch until ctx is done; dispatch
+// reports whether the arrival was missed. The rate is integrated over time in
+// steps of at most idleRateCheck, so a changing rate takes effect within a
+// step: a ramp from zero starts once its first arrival is due instead of
+// waiting out the gap of its initial, tiny rate.
+func (ts *TestSession) scheduleArrivals(ctx context.Context, distribution ArrivalDistribution, rateAt func(elapsed time.Duration) float64, dispatch func(ctx context.Context, scheduled time.Time) bool) {
+	start := time.Now()
+	next := start
+	// recorded is when the target arrivals were last recorded. They follow
+	// the clock rather than the schedule, so a late scheduler does not lower
+	// the target.
+	recorded := start
+
+	// need is how many arrivals' worth of rate must pass until the next
+	// arrival: one for evenly spaced arrivals, a random amount for Poisson
+	draw := func() float64 {
+		if distribution == ArrivalPoisson {
+			return rand.ExpFloat64()
+		}
+		return 1
+	}
+	need := draw()
+
+	for {
+		rate := rateAt(next.Sub(start))
+
+		step := idleRateCheck
+		arrival := false
+		if rate > 0 {
+			if due := time.Duration(need / rate * float64(time.Second)); due <= step {
+				step = due
+				arrival = true
+			} else {
+				need -= rate * step.Seconds()
+			}
+		}
+		next = next.Add(step)
+
+		// Arrivals follow the schedule, not the time the previous one was
+		// handed out, so a late scheduler catches up instead of drifting
+		if !sleepContext(ctx, time.Until(next)) {
+			return
+		}
+
+		now := time.Now()
+		ts.dataCollector.recordTarget(rateAt(now.Sub(start)) * now.Sub(recorded).Seconds())
+		recorded = now
+
+		if arrival {
+			ts.dataCollector.recordArrival(dispatch(ctx, next))
+			need = draw()
+		}
+	}
+}
+
+// missGrace is how long an arrival that finds every worker busy waits for
+// one to finish before it is counted as missed
+const missGrace = time.Millisecond
+
+// openPool is the worker pool of the open-model executor. Workers are
+// started as arrivals need them, up to maxWorkers.
+type openPool struct {
+	session      *TestSession
+	fn           func(ctx context.Context) error
+	selfReported bool
+	// arrivals is unbuffered, so an arrival is only accepted by an idle worker
+	arrivals   chan time.Time
+	workers    int
+	maxWorkers int
+	wg         sync.WaitGroup
+}
+
+// dispatch hands an arrival to an idle worker, or to a new one while the
+// pool is below its limit. With every worker busy it waits up to missGrace
+// for one to finish, unless the schedule is already behind, and reports the
+// arrival as missed otherwise.
+func (p *openPool) dispatch(ctx context.Context, scheduled time.Time) bool {
+	select {
+	case p.arrivals <- scheduled:
+		return false
+	default:
+	}
+
+	if p.workers < p.maxWorkers {
+		p.workers++
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.runWorker(ctx, scheduled)
+		}()
+		return false
+	}
+
+	grace := missGrace - time.Since(scheduled)
+	if grace <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case p.arrivals <- scheduled:
+		return false
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return true
+	}
+}
+
+// runWorker runs the first iteration of a worker and then the iterations
+// handed to it until ctx is done
+func (p *openPool) runWorker(ctx context.Context, first time.Time) {
+	for scheduled := first; ; {
+		tok := p.session.beginCall(p.selfReported)
+		tok.intended = scheduled
+		if !p.session.iterate(ctx, tok, p.fn) {
+			return
+		}
+
+		select {
+		case scheduled = <-p.arrivals:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordArrival counts an arrival of the open-model executor in the current
+// interval, and whether it was missed because every worker was busy
+func (dc *DataCollector) recordArrival(missed bool) {
+	if !dc.running.Load() {
+		return
+	}
+
+	if missed {
+		atomic.AddInt64(&dc.missedIterations, 1)
+	}
+
+	shard := dc.shards[rand.Uint64()&dc.shardMask]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	bucket := dc.openBucket(shard, time.Now().UnixNano()/int64(dc.interval))
+	bucket.Scheduled++
+	if missed {
+		bucket.Missed++
+	}
+}
+
+// recordTarget adds the arrivals the configured rate of the open-model
+// executor asked for since it was last called to the current interval
+func (dc *DataCollector) recordTarget(arrivals float64) {
+	if !dc.running.Load() || arrivals <= 0 {
+		return
+	}
+
+	shard := dc.shards[rand.Uint64()&dc.shardMask]
+
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	dc.openBucket(shard, time.Now().UnixNano()/int64(dc.interval)).Target += arrivals
+}
+
+// GetMissedIterations returns how many arrivals of the open-model executor
+// found every worker busy
+func (dc *DataCollector) GetMissedIterations() int64 {
+	return atomic.LoadInt64(&dc.missedIterations)
+}
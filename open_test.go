@@ -0,0 +1,198 @@
+package ptest
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOpenPoolDispatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxWorkers  int
+		arrivals    int
+		gap         time.Duration
+		block       bool
+		wantMissed  int
+		wantWorkers int
+	}{
+		// A finished worker takes the next arrival instead of a new one
+		{"idle workers are reused", 100, 20, 5 * time.Millisecond, false, 0, 1},
+		{"busy workers grow the pool", 10, 5, 0, true, 0, 5},
+		{"full pool misses", 2, 5, 0, true, 3, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran atomic.Int64
+			runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithCancel(context.Background())
+
+				pool := &openPool{
+					session:  ts,
+					arrivals: make(chan time.Time),
+					fn: func(ctx context.Context) error {
+						ran.Add(1)
+						if tt.block {
+							<-ctx.Done()
+						}
+						return nil
+					},
+					maxWorkers: tt.maxWorkers,
+				}
+
+				missed := 0
+				for i := 0; i < tt.arrivals; i++ {
+					time.Sleep(tt.gap)
+					if pool.dispatch(ctx, time.Now()) {
+						missed++
+					}
+				}
+				cancel()
+				pool.wg.Wait()
+
+				if missed != tt.wantMissed {
+					t.Errorf("missed %d arrivals, want %d", missed, tt.wantMissed)
+				}
+				if pool.workers != tt.wantWorkers {
+					t.Errorf("started %d workers, want %d", pool.workers, tt.wantWorkers)
+				}
+			})
+
+			if got, want := ran.Load(), int64(tt.arrivals-tt.wantMissed); got != want {
+				t.Errorf("ran %d iterations, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestRunOpenNoMissesWhenIdle(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution ArrivalDistribution
+	}{
+		{"uniform", ArrivalUniform},
+		{"poisson", ArrivalPoisson},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ran atomic.Int64
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+				defer cancel()
+
+				config := OpenConfig{Rate: 200, Distribution: tt.distribution}
+				err := ts.RunOpen(ctx, config, func(ctx context.Context) error {
+					ran.Add(1)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("RunOpen() = %v", err)
+				}
+			})
+
+			if ran.Load() == 0 {
+				t.Fatal("no iterations ran")
+			}
+			if missed := ts.dataCollector.GetMissedIterations(); missed != 0 {
+				t.Errorf("missed %d iterations with idle workers", missed)
+			}
+		})
+	}
+}
+
+func TestScheduleArrivals(t *testing.T) {
+	tests := []struct {
+		name         string
+		distribution ArrivalDistribution
+		rateAt       func(elapsed time.Duration) float64
+		duration     time.Duration
+		// firstBy bounds the time of the first arrival, zero for no arrivals
+		firstBy  time.Duration
+		min, max int
+	}{
+		{"constant", ArrivalUniform, func(time.Duration) float64 { return 100 }, 500 * time.Millisecond, 20 * time.Millisecond, 45, 51},
+		{"poisson", ArrivalPoisson, func(time.Duration) float64 { return 100 }, 500 * time.Millisecond, 200 * time.Millisecond, 20, 90},
+		{"zero", ArrivalUniform, func(time.Duration) float64 { return 0 }, 300 * time.Millisecond, 0, 0, 0},
+		// The first arrival of a ramp from zero to r over T is due after
+		// sqrt(2T/r): about 1.1s here
+		{"ramp from zero", ArrivalUniform, Profile{Ramp(100, time.Minute)}.targetAt, 2 * time.Second, 1500 * time.Millisecond, 1, 5},
+		{"rate rising late", ArrivalUniform, func(elapsed time.Duration) float64 {
+			if elapsed < 200*time.Millisecond {
+				return 0.001
+			}
+			return 100
+		}, 500 * time.Millisecond, 300 * time.Millisecond, 20, 31},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var arrivals []time.Duration
+			start := time.Now()
+
+			runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithTimeout(context.Background(), tt.duration)
+				defer cancel()
+
+				ts.scheduleArrivals(ctx, tt.distribution, tt.rateAt, func(ctx context.Context, scheduled time.Time) bool {
+					arrivals = append(arrivals, scheduled.Sub(start))
+					return false
+				})
+			})
+
+			if len(arrivals) < tt.min || len(arrivals) > tt.max {
+				t.Errorf("got %d arrivals, want %d to %d", len(arrivals), tt.min, tt.max)
+			}
+			if len(arrivals) > 0 && tt.firstBy > 0 && arrivals[0] > tt.firstBy {
+				t.Errorf("first arrival after %v, want by %v", arrivals[0], tt.firstBy)
+			}
+		})
+	}
+}
+
+func TestTargetRateWithLateScheduler(t *testing.T) {
+	const (
+		rate     = 200
+		duration = 500 * time.Millisecond
+	)
+
+	tests := []struct {
+		name         string
+		dispatchTime time.Duration
+		maxScheduled int64
+	}{
+		{"keeping up", 0, rate},
+		// Every dispatch holds the scheduler up for four arrivals' worth
+		{"falling behind", 20 * time.Millisecond, rate / 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithTimeout(context.Background(), duration)
+				defer cancel()
+
+				ts.scheduleArrivals(ctx, ArrivalUniform, func(time.Duration) float64 { return rate }, func(ctx context.Context, scheduled time.Time) bool {
+					time.Sleep(tt.dispatchTime)
+					return false
+				})
+			})
+
+			var target float64
+			var scheduled int64
+			for _, stat := range ts.GetOptimizedChartData().Tiers[0].Stats {
+				target += stat.TargetRate * float64(stat.IntervalMs) / 1000
+				scheduled += stat.ScheduledIterations
+			}
+			if scheduled > tt.maxScheduled {
+				t.Errorf("scheduled %d arrivals, want at most %d", scheduled, tt.maxScheduled)
+			}
+			// The target covers the whole run at the configured rate
+			if want := rate * duration.Seconds(); target < 0.9*want || target > 1.1*want {
+				t.Errorf("target rate asked for %.1f arrivals, want about %.0f", target, want)
+			}
+		})
+	}
+}
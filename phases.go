@@ -0,0 +1,271 @@
+package ptest
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// HTTPPhases holds the durations of the phases of one HTTP request
+type HTTPPhases struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// Wait is from the request being written to the first response byte:
+	// server processing plus one network round trip
+	Wait time.Duration
+	// Transfer is from the first response byte to the end of the body
+	Transfer time.Duration
+	// Reused is true if the request went over a kept-alive connection, which
+	// skips DNS, connect and TLS
+	Reused bool
+}
+
+// phaseTracer records the phase timings of one HTTP request through
+// httptrace hooks, which may run on several goroutines
+type phaseTracer struct {
+	mutex sync.Mutex
+
+	dnsStart, connectStart, tlsStart time.Time
+	wrote, firstByte, end            time.Time
+	phases                           HTTPPhases
+
+	// statusCode and size describe the response once it arrived
+	statusCode int
+	size       int64
+}
+
+// clientTrace returns the httptrace hooks of the tracer
+func (p *phaseTracer) clientTrace() *httptrace.ClientTrace {
+	now := func(at *time.Time) {
+		p.mutex.Lock()
+		*at = time.Now()
+		p.mutex.Unlock()
+	}
+	since := func(start *time.Time, phase *time.Duration) {
+		p.mutex.Lock()
+		if !start.IsZero() {
+			*phase = time.Since(*start)
+		}
+		p.mutex.Unlock()
+	}
+
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { now(&p.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { since(&p.dnsStart, &p.phases.DNS) },
+		ConnectStart: func(string, string) {
+			// Dialing several addresses at once keeps the first start
+			p.mutex.Lock()
+			if p.connectStart.IsZero() {
+				p.connectStart = time.Now()
+			}
+			p.mutex.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				since(&p.connectStart, &p.phases.Connect)
+			}
+		},
+		TLSHandshakeStart: func() { now(&p.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { since(&p.tlsStart, &p.phases.TLS) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.mutex.Lock()
+			p.phases.Reused = info.Reused
+			p.mutex.Unlock()
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { now(&p.wrote) },
+		GotFirstResponseByte: func() { now(&p.firstByte) },
+	}
+}
+
+// finish marks the end of the response body
+func (p *phaseTracer) finish() {
+	p.mutex.Lock()
+	if p.end.IsZero() {
+		p.end = time.Now()
+	}
+	p.mutex.Unlock()
+}
+
+// respond records the status code of the response
+func (p *phaseTracer) respond(statusCode int) {
+	p.mutex.Lock()
+	p.statusCode = statusCode
+	p.mutex.Unlock()
+}
+
+// addSize counts bytes of the response body
+func (p *phaseTracer) addSize(n int64) {
+	p.mutex.Lock()
+	p.size += n
+	p.mutex.Unlock()
+}
+
+// response returns the status code and size of the response, zero if none arrived
+func (p *phaseTracer) response() (int, int64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.statusCode, p.size
+}
+
+// result returns the recorded phases, or nil if no response arrived. An
+// unfinished transfer is counted up to now.
+func (p *phaseTracer) result() *HTTPPhases {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.firstByte.IsZero() {
+		return nil
+	}
+
+	phases := p.phases
+	if !p.wrote.IsZero() {
+		phases.Wait = p.firstByte.Sub(p.wrote)
+	}
+	end := p.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	phases.Transfer = end.Sub(p.firstByte)
+	return &phases
+}
+
+// trace returns the request with the tracer's hooks added to its context
+func (p *phaseTracer) trace(req *http.Request) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), p.clientTrace()))
+}
+
+// TraceHTTP returns the request with httptrace hooks that record its phase
+// timings, and a function to call with the status code and body size of the
+// response once its body is read. The phases and the response are reported
+// with tok.
+func TraceHTTP(req *http.Request, tok *Token) (*http.Request, func(statusCode int, size int64)) {
+	tracer := &phaseTracer{}
+	tok.tracer = tracer
+
+	finish := func(statusCode int, size int64) {
+		tracer.finish()
+		tracer.respond(statusCode)
+		tracer.addSize(size)
+	}
+	return tracer.trace(req), finish
+}
+
+// phaseSums adds up the phases of the HTTP requests of an interval
+type phaseSums struct {
+	count    int64
+	reused   int64
+	dns      time.Duration
+	connect  time.Duration
+	tls      time.Duration
+	wait     time.Duration
+	transfer time.Duration
+}
+
+// add adds the phases of one request
+func (s *phaseSums) add(phases *HTTPPhases) {
+	s.count++
+	if phases.Reused {
+		s.reused++
+	}
+	s.dns += phases.DNS
+	s.connect += phases.Connect
+	s.tls += phases.TLS
+	s.wait += phases.Wait
+	s.transfer += phases.Transfer
+}
+
+// merge adds the sums of another interval
+func (s *phaseSums) merge(other *phaseSums) {
+	s.count += other.count
+	s.reused += other.reused
+	s.dns += other.dns
+	s.connect += other.connect
+	s.tls += other.tls
+	s.wait += other.wait
+	s.transfer += other.transfer
+}
+
+// mergePhases merges src into dst, creating dst on first use
+func mergePhases(dst, src *phaseSums) *phaseSums {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = &phaseSums{}
+	}
+	dst.merge(src)
+	return dst
+}
+
+// PhaseStat holds the mean duration in milliseconds of every phase of the
+// HTTP requests of a period that recorded phases
+type PhaseStat struct {
+	// Count is the number of requests with phase timings
+	Count    int64   `json:"count"`
+	DNS      float64 `json:"dns"`
+	Connect  float64 `json:"connect"`
+	TLS      float64 `json:"tls"`
+	Wait     float64 `json:"wait"`
+	Transfer float64 `json:"transfer"`
+	// ConnReuse is the percentage of requests sent over a reused connection
+	ConnReuse float64 `json:"conn_reuse"`
+}
+
+// phaseStat computes the mean phases of an interval, or nil without any
+func phaseStat(sums *phaseSums) *PhaseStat {
+	if sums == nil || sums.count == 0 {
+		return nil
+	}
+
+	mean := func(total time.Duration) float64 {
+		return durationToMillis(total) / float64(sums.count)
+	}
+	return &PhaseStat{
+		Count:     sums.count,
+		DNS:       mean(sums.dns),
+		Connect:   mean(sums.connect),
+		TLS:       mean(sums.tls),
+		Wait:      mean(sums.wait),
+		Transfer:  mean(sums.transfer),
+		ConnReuse: float64(sums.reused) / float64(sums.count) * 100,
+	}
+}
+
+// aggregatePhases combines the phases of several periods, weighted by their
+// request counts
+func aggregatePhases(stats []*Stat) *PhaseStat {
+	var total PhaseStat
+	var reused float64
+
+	for _, stat := range stats {
+		phases := stat.Phases
+		if phases == nil {
+			continue
+		}
+
+		weight := float64(phases.Count)
+		total.Count += phases.Count
+		total.DNS += phases.DNS * weight
+		total.Connect += phases.Connect * weight
+		total.TLS += phases.TLS * weight
+		total.Wait += phases.Wait * weight
+		total.Transfer += phases.Transfer * weight
+		reused += phases.ConnReuse * weight
+	}
+
+	if total.Count == 0 {
+		return nil
+	}
+
+	count := float64(total.Count)
+	total.DNS /= count
+	total.Connect /= count
+	total.TLS /= count
+	total.Wait /= count
+	total.Transfer /= count
+	total.ConnReuse = reused / count
+	return &total
+}
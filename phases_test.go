@@ -0,0 +1,99 @@
+package ptest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPhaseStat(t *testing.T) {
+	tests := []struct {
+		name   string
+		phases []*HTTPPhases
+		want   *PhaseStat
+	}{
+		{"untraced", []*HTTPPhases{nil, nil}, nil},
+		{"new connection", []*HTTPPhases{{DNS: 2 * time.Millisecond, Connect: 4 * time.Millisecond, Wait: 10 * time.Millisecond, Transfer: time.Millisecond}},
+			&PhaseStat{Count: 1, DNS: 2, Connect: 4, Wait: 10, Transfer: 1}},
+		{"reused connection", []*HTTPPhases{{Wait: 10 * time.Millisecond, Reused: true}, {Connect: 2 * time.Millisecond, Wait: 20 * time.Millisecond}, nil},
+			&PhaseStat{Count: 2, Connect: 1, Wait: 15, ConnReuse: 50}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Split the trips over two buckets to cover merging
+			first := newTripsOfSec(time.Now(), DefaultInterval, nil, DefaultHistogramPrecision)
+			second := newTripsOfSec(time.Now(), DefaultInterval, nil, DefaultHistogramPrecision)
+			for i, phases := range tt.phases {
+				bucket := first
+				if i%2 == 1 {
+					bucket = second
+				}
+				bucket.add(time.Millisecond, &Trip{Success: true, Phases: phases})
+			}
+			first.merge(second)
+
+			got := phaseStat(first.phases)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("phaseStat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraceHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("hello"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		requests int
+		reused   bool
+	}{
+		{"first request", 1, false},
+		{"kept-alive connection", 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{}}
+			defer client.CloseIdleConnections()
+
+			var trip Trip
+			for i := 0; i < tt.requests; i++ {
+				tok := &Token{}
+				req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+				req, finish := TraceHTTP(req, tok)
+
+				resp, err := client.Do(req)
+				if err != nil {
+					t.Fatalf("GET = %v", err)
+				}
+				size, _ := io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				finish(resp.StatusCode, size)
+
+				trip = tok.trip()
+			}
+
+			if trip.Phases == nil {
+				t.Fatal("no phases recorded")
+			}
+			if trip.Phases.Reused != tt.reused {
+				t.Errorf("reused = %v, want %v", trip.Phases.Reused, tt.reused)
+			}
+			if trip.Phases.Wait < 5*time.Millisecond {
+				t.Errorf("wait %v, want at least the handler's 5ms", trip.Phases.Wait)
+			}
+			if trip.StatusCode != http.StatusAccepted || trip.ResponseSize != 5 {
+				t.Errorf("response %d of %d bytes, want %d of 5", trip.StatusCode, trip.ResponseSize, http.StatusAccepted)
+			}
+		})
+	}
+}
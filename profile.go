@@ -0,0 +1,223 @@
+package ptest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// profileTick is how often the closed-model executor adjusts the number of
+// virtual users to the profile
+const profileTick = 100 * time.Millisecond
+
+// Stage is one stage of a load profile. Its target is an arrival rate for
+// RunOpenProfile and a number of virtual users for RunClosedProfile.
+type Stage struct {
+	// Name annotates the session timeline when the stage begins
+	Name string
+	// Duration is how long the stage lasts
+	Duration time.Duration
+	// Target is the load at the end of the stage
+	Target float64
+	// Jump starts the stage at its target instead of ramping to it linearly
+	// from the previous stage's target
+	Jump bool
+}
+
+// Ramp changes the load linearly from the previous stage's target, or from
+// zero for the first stage, to target over the given duration
+func Ramp(target float64, over time.Duration) Stage {
+	return Stage{Name: fmt.Sprintf("ramp to %g", target), Duration: over, Target: target}
+}
+
+// Step jumps to target and holds it for the given duration
+func Step(target float64, duration time.Duration) Stage {
+	return Stage{Name: fmt.Sprintf("step to %g", target), Duration: duration, Target: target, Jump: true}
+}
+
+// Spike jumps to a short burst of load; follow it with a Ramp or Step back down
+func Spike(target float64, duration time.Duration) Stage {
+	return Stage{Name: fmt.Sprintf("spike to %g", target), Duration: duration, Target: target, Jump: true}
+}
+
+// Soak holds target for a long time, to surface leaks and slow degradation
+func Soak(target float64, duration time.Duration) Stage {
+	return Stage{Name: fmt.Sprintf("soak at %g", target), Duration: duration, Target: target, Jump: true}
+}
+
+// Profile is a sequence of stages run one after another
+type Profile []Stage
+
+// Duration returns the total duration of the profile
+func (p Profile) Duration() time.Duration {
+	var total time.Duration
+	for _, stage := range p {
+		total += stage.Duration
+	}
+	return total
+}
+
+// targetAt returns the load the profile asks for after elapsed; past the
+// last stage it keeps the last target
+func (p Profile) targetAt(elapsed time.Duration) float64 {
+	previous := 0.0
+	for _, stage := range p {
+		if elapsed < stage.Duration {
+			if stage.Jump {
+				return stage.Target
+			}
+			progress := float64(elapsed) / float64(stage.Duration)
+			return previous + (stage.Target-previous)*progress
+		}
+		elapsed -= stage.Duration
+		previous = stage.Target
+	}
+	return previous
+}
+
+// Annotation marks a moment on the session timeline, e.g. the start of a
+// load profile stage
+type Annotation struct {
+	TimeMs int64  `json:"time_ms"`
+	Text   string `json:"text"`
+}
+
+// Annotate marks the current moment on the session timeline
+func (ts *TestSession) Annotate(text string) {
+	if !ts.running() {
+		return
+	}
+
+	ts.annotationMutex.Lock()
+	defer ts.annotationMutex.Unlock()
+
+	ts.annotations = append(ts.annotations, Annotation{
+		TimeMs: time.Now().UnixMilli(),
+		Text:   text,
+	})
+}
+
+// Annotate marks the current moment on the timeline of the current session
+func (tr *TestRunner) Annotate(text string) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil {
+		session.Annotate(text)
+	}
+}
+
+// GetAnnotations returns the annotations of the session in time order
+func (ts *TestSession) GetAnnotations() []Annotation {
+	ts.annotationMutex.RLock()
+	defer ts.annotationMutex.RUnlock()
+
+	return append([]Annotation(nil), ts.annotations...)
+}
+
+// annotateStages annotates the start of every stage until ctx is done
+func (ts *TestSession) annotateStages(ctx context.Context, profile Profile) {
+	for _, stage := range profile {
+		ts.Annotate(stage.Name)
+		if !sleepContext(ctx, stage.Duration) {
+			return
+		}
+	}
+}
+
+// RunOpenProfile runs an open-model load test like RunOpen, with the arrival
+// rate following the profile instead of config.Rate. It returns once the
+// profile is complete, ctx is done or the session is stopped.
+func (tr *TestRunner) RunOpenProfile(ctx context.Context, profile Profile, config OpenConfig, fn func(ctx context.Context) error) error {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return ErrNoSession
+	}
+	return session.RunOpenProfile(ctx, profile, config, fn)
+}
+
+// RunOpenProfile runs a staged open-model load test against the session; see
+// TestRunner.RunOpenProfile
+func (ts *TestSession) RunOpenProfile(ctx context.Context, profile Profile, config OpenConfig, fn func(ctx context.Context) error) error {
+	if !ts.running() {
+		return ErrNoSession
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, profile.Duration())
+	defer cancel()
+
+	go ts.annotateStages(ctx, profile)
+	return ts.runOpen(ctx, config, profile.targetAt, fn)
+}
+
+// RunClosedProfile runs a closed-model load test like RunClosedWithConfig,
+// with the number of virtual users following the profile instead of
+// config.Users. Virtual users above the target finish their current
+// iteration and leave. It returns once the profile is complete, ctx is done
+// or the session is stopped.
+func (tr *TestRunner) RunClosedProfile(ctx context.Context, profile Profile, config ClosedConfig, fn func(ctx context.Context, vu *VU) error) error {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return ErrNoSession
+	}
+	return session.RunClosedProfile(ctx, profile, config, fn)
+}
+
+// RunClosedProfile runs a staged closed-model load test against the session;
+// see TestRunner.RunClosedProfile
+func (ts *TestSession) RunClosedProfile(ctx context.Context, profile Profile, config ClosedConfig, fn func(ctx context.Context, vu *VU) error) error {
+	if !ts.running() {
+		return ErrNoSession
+	}
+
+	ctx, cancel := ts.runContext(ctx)
+	defer cancel()
+	ctx, cancelProfile := context.WithTimeout(ctx, profile.Duration())
+	defer cancelProfile()
+
+	go ts.annotateStages(ctx, profile)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var users []*VU
+	nextID := 0
+	start := time.Now()
+	ticker := time.NewTicker(profileTick)
+	defer ticker.Stop()
+
+	for {
+		target := int(math.Round(profile.targetAt(time.Since(start))))
+		for len(users) < target {
+			vu := &VU{ID: nextID}
+			nextID++
+			users = append(users, vu)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ts.runVU(ctx, vu, config, fn)
+			}()
+		}
+		// The newest virtual users leave first
+		for len(users) > target {
+			users[len(users)-1].retired.Store(true)
+			users = users[:len(users)-1]
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package ptest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProfileTargetAt(t *testing.T) {
+	profile := Profile{
+		Ramp(100, 10*time.Second),
+		Step(50, 5*time.Second),
+		Ramp(150, 10*time.Second),
+		Spike(400, time.Second),
+	}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		elapsed time.Duration
+		want    float64
+	}{
+		{"start of ramp from zero", profile, 0, 0},
+		{"middle of ramp", profile, 5 * time.Second, 50},
+		{"end of ramp", profile, 10*time.Second - time.Millisecond, 99.99},
+		{"start of step", profile, 10 * time.Second, 50},
+		{"end of step", profile, 15*time.Second - time.Millisecond, 50},
+		{"ramp starts at the step's target", profile, 15 * time.Second, 50},
+		{"middle of second ramp", profile, 20 * time.Second, 100},
+		{"start of spike", profile, 25 * time.Second, 400},
+		{"past the end", profile, time.Hour, 400},
+		{"empty profile", nil, time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.profile.targetAt(tt.elapsed); got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("targetAt(%v) = %v, want %v", tt.elapsed, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := profile.Duration(), 26*time.Second; got != want {
+		t.Errorf("Duration() = %v, want %v", got, want)
+	}
+}
+
+func TestAnnotateStages(t *testing.T) {
+	const stage = 30 * time.Millisecond
+	profile := Profile{Step(10, stage), Ramp(20, stage), {Name: "cool down", Duration: stage}}
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		want    []string
+	}{
+		{"whole profile", time.Second, []string{"step to 10", "ramp to 20", "cool down"}},
+		{"canceled during the first stage", stage / 2, []string{"step to 10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+				defer cancel()
+				ts.annotateStages(ctx, profile)
+			})
+
+			annotations := ts.GetAnnotations()
+			if len(annotations) != len(tt.want) {
+				t.Fatalf("got annotations %+v, want %v", annotations, tt.want)
+			}
+			for i, annotation := range annotations {
+				if annotation.Text != tt.want[i] {
+					t.Errorf("annotation %d is %q, want %q", i, annotation.Text, tt.want[i])
+				}
+				if i > 0 && annotation.TimeMs-annotations[i-1].TimeMs < stage.Milliseconds() {
+					t.Errorf("annotation %d follows the previous one after %dms, want at least %v", i, annotation.TimeMs-annotations[i-1].TimeMs, stage)
+				}
+			}
+		})
+	}
+}
+
+func TestRunClosedProfileUsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile Profile
+		// wantUsers is how many virtual users run over the whole profile
+		wantUsers int
+		// Virtual users from ID keep are retired by retiredBy
+		keep      int
+		retiredBy time.Duration
+		// The last virtual user joins no earlier than lastJoinsAfter
+		lastJoinsAfter time.Duration
+	}{
+		{"step up and down", Profile{Step(3, 300*time.Millisecond), Step(1, 300*time.Millisecond)}, 3, 1, 450 * time.Millisecond, 0},
+		// The target rounds to 4 users after 350ms
+		{"ramp up", Profile{Ramp(4, 400*time.Millisecond), Step(4, 200*time.Millisecond)}, 4, 4, 0, 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			first := make(map[int]time.Duration)
+			last := make(map[int]time.Duration)
+			var start time.Time
+
+			runSession(t, SessionConfig{}, func(ts *TestSession) {
+				start = time.Now()
+				err := ts.RunClosedProfile(context.Background(), tt.profile, ClosedConfig{}, func(ctx context.Context, vu *VU) error {
+					elapsed := time.Since(start)
+					mutex.Lock()
+					if _, ok := first[vu.ID]; !ok {
+						first[vu.ID] = elapsed
+					}
+					last[vu.ID] = elapsed
+					mutex.Unlock()
+
+					sleepContext(ctx, 10*time.Millisecond)
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("RunClosedProfile() = %v", err)
+				}
+			})
+
+			if len(first) != tt.wantUsers {
+				t.Fatalf("%d virtual users ran, want %d", len(first), tt.wantUsers)
+			}
+			for id := tt.keep; id < tt.wantUsers; id++ {
+				if last[id] > tt.retiredBy {
+					t.Errorf("virtual user %d ran until %v, want it retired by %v", id, last[id], tt.retiredBy)
+				}
+			}
+			if joined := first[tt.wantUsers-1]; joined < tt.lastJoinsAfter {
+				t.Errorf("virtual user %d joined after %v, want no earlier than %v", tt.wantUsers-1, joined, tt.lastJoinsAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package ptest
+
+// responseSums counts the status codes and adds up the sizes of the
+// responses of an interval
+type responseSums struct {
+	count       int64
+	bytes       int64
+	maxBytes    int64
+	statusCodes map[int]int64
+}
+
+// add adds the response of one trip
+func (s *responseSums) add(trip *Trip) {
+	s.count++
+	s.bytes += trip.ResponseSize
+	if trip.ResponseSize > s.maxBytes {
+		s.maxBytes = trip.ResponseSize
+	}
+	if s.statusCodes == nil {
+		s.statusCodes = make(map[int]int64)
+	}
+	s.statusCodes[trip.StatusCode]++
+}
+
+// merge adds the sums of another interval
+func (s *responseSums) merge(other *responseSums) {
+	s.count += other.count
+	s.bytes += other.bytes
+	if other.maxBytes > s.maxBytes {
+		s.maxBytes = other.maxBytes
+	}
+	for code, count := range other.statusCodes {
+		if s.statusCodes == nil {
+			s.statusCodes = make(map[int]int64)
+		}
+		s.statusCodes[code] += count
+	}
+}
+
+// mergeResponses merges src into dst, creating dst on first use
+func mergeResponses(dst, src *responseSums) *responseSums {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = &responseSums{}
+	}
+	dst.merge(src)
+	return dst
+}
+
+// ResponseStat holds the status code distribution and the response sizes in
+// bytes of the requests of a period that recorded a response
+type ResponseStat struct {
+	// Count is the number of requests with a response
+	Count       int64         `json:"count"`
+	StatusCodes map[int]int64 `json:"status_codes"`
+	TotalBytes  int64         `json:"total_bytes"`
+	MeanBytes   float64       `json:"mean_bytes"`
+	MaxBytes    int64         `json:"max_bytes"`
+}
+
+// responseStat computes the responses of an interval, or nil without any.
+// Sampled intervals count every recorded response scale times.
+func responseStat(sums *responseSums, scale int64) *ResponseStat {
+	if sums == nil || sums.count == 0 {
+		return nil
+	}
+
+	stat := &ResponseStat{
+		Count:       sums.count * scale,
+		StatusCodes: make(map[int]int64, len(sums.statusCodes)),
+		TotalBytes:  sums.bytes * scale,
+		MaxBytes:    sums.maxBytes,
+	}
+	for code, count := range sums.statusCodes {
+		stat.StatusCodes[code] = count * scale
+	}
+	stat.MeanBytes = float64(stat.TotalBytes) / float64(stat.Count)
+	return stat
+}
+
+// mergeResponseStat adds src to dst, creating dst on first use so the
+// stats of single periods are never modified
+func mergeResponseStat(dst, src *ResponseStat) *ResponseStat {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		dst = &ResponseStat{StatusCodes: make(map[int]int64, len(src.StatusCodes))}
+	}
+
+	dst.Count += src.Count
+	dst.TotalBytes += src.TotalBytes
+	if src.MaxBytes > dst.MaxBytes {
+		dst.MaxBytes = src.MaxBytes
+	}
+	for code, count := range src.StatusCodes {
+		dst.StatusCodes[code] += count
+	}
+	dst.MeanBytes = float64(dst.TotalBytes) / float64(dst.Count)
+	return dst
+}
+
+// aggregateResponses combines the responses of several periods
+func aggregateResponses(stats []*Stat) *ResponseStat {
+	var total *ResponseStat
+	for _, stat := range stats {
+		total = mergeResponseStat(total, stat.Responses)
+	}
+	return total
+}
+
+// copy returns a copy that does not share the status code counts
+func (r *ResponseStat) copy() *ResponseStat {
+	if r == nil {
+		return nil
+	}
+	return mergeResponseStat(nil, r)
+}
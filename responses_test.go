@@ -0,0 +1,50 @@
+package ptest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResponseStat(t *testing.T) {
+	tests := []struct {
+		name  string
+		trips []Trip
+		scale int64
+		want  *ResponseStat
+	}{
+		{"no response", []Trip{{}, {}}, 1, nil},
+		{"status codes and sizes", []Trip{{StatusCode: 200, ResponseSize: 100}, {StatusCode: 200, ResponseSize: 300}, {StatusCode: 503}}, 1,
+			&ResponseStat{Count: 3, StatusCodes: map[int]int64{200: 2, 503: 1}, TotalBytes: 400, MeanBytes: 400.0 / 3, MaxBytes: 300}},
+		{"sampled", []Trip{{StatusCode: 200, ResponseSize: 10}}, 10,
+			&ResponseStat{Count: 10, StatusCodes: map[int]int64{200: 10}, TotalBytes: 100, MeanBytes: 10, MaxBytes: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := newTripsOfSec(time.Now(), DefaultInterval, nil, DefaultHistogramPrecision)
+			for i := range tt.trips {
+				bucket.add(0, &tt.trips[i])
+			}
+			sums := bucket.responses
+
+			if got := responseStat(sums, tt.scale); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("responseStat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeResponseStat(t *testing.T) {
+	first := &ResponseStat{Count: 2, StatusCodes: map[int]int64{200: 2}, TotalBytes: 200, MeanBytes: 100, MaxBytes: 150}
+	second := &ResponseStat{Count: 2, StatusCodes: map[int]int64{200: 1, 404: 1}, TotalBytes: 20, MeanBytes: 10, MaxBytes: 15}
+
+	merged := aggregateResponses([]*Stat{{Responses: first}, {}, {Responses: second}})
+	want := &ResponseStat{Count: 4, StatusCodes: map[int]int64{200: 3, 404: 1}, TotalBytes: 220, MeanBytes: 55, MaxBytes: 150}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("aggregateResponses() = %+v, want %+v", merged, want)
+	}
+	if first.StatusCodes[200] != 2 {
+		t.Errorf("merging modified the stat of a single period: %v", first.StatusCodes)
+	}
+}
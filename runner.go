@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 )
@@ -20,6 +21,9 @@ type TestRunner struct {
 	webViewer      *WebViewer
 	mutex          sync.RWMutex
 	isOwnServer    bool
+
+	// config is used by sessions started with StartTest
+	config SessionConfig
 }
 
 // NewTestRunner creates a TestRunner with its own HTTP server
@@ -27,6 +31,7 @@ func NewTestRunner(addr string) *TestRunner {
 	tr := &TestRunner{
 		sessions:    make(map[string]*TestSession),
 		isOwnServer: true,
+		config:      DefaultSessionConfig(),
 		mutex:       sync.RWMutex{},
 	}
 
@@ -39,6 +44,7 @@ func NewTestRunnerWithHandler(registrar HandlerRegistrar) *TestRunner {
 	tr := &TestRunner{
 		sessions:    make(map[string]*TestSession),
 		isOwnServer: false,
+		config:      DefaultSessionConfig(),
 		mutex:       sync.RWMutex{},
 	}
 
@@ -46,19 +52,34 @@ func NewTestRunnerWithHandler(registrar HandlerRegistrar) *TestRunner {
 	return tr
 }
 
-// StartTest creates and starts a new test session
+// StartTest creates and starts a new test session with the runner's configuration
 func (tr *TestRunner) StartTest(name string) *TestSession {
+	tr.mutex.RLock()
+	config := tr.config
+	tr.mutex.RUnlock()
+
+	return tr.StartTestWithConfig(name, config)
+}
+
+// StartTestWithConfig creates and starts a new test session with its own
+// configuration. A configuration that fails Validate is logged, and its
+// intervals are rounded up.
+func (tr *TestRunner) StartTestWithConfig(name string, config SessionConfig) *TestSession {
+	if err := config.Validate(); err != nil {
+		log.Printf("%v; rounding up", err)
+	}
+
 	tr.mutex.Lock()
 	defer tr.mutex.Unlock()
 
 	// Stop current session if running
-	if tr.currentSession != nil && tr.currentSession.Status == StatusRunning {
+	if tr.currentSession != nil && tr.currentSession.running() {
 		tr.currentSession.stop()
 	}
 
 	// Create new session
 	sessionID := generateSessionID()
-	session := newTestSession(sessionID, name)
+	session := newTestSession(sessionID, name, config)
 
 	tr.sessions[sessionID] = session
 	tr.currentSession = session
@@ -78,7 +99,7 @@ func (tr *TestRunner) StopTest() {
 	tr.mutex.Lock()
 	defer tr.mutex.Unlock()
 
-	if tr.currentSession != nil && tr.currentSession.Status == StatusRunning {
+	if tr.currentSession != nil && tr.currentSession.running() {
 		tr.currentSession.stop()
 		tr.webViewer.onSessionStop(tr.currentSession)
 		log.Printf("Stopped test session: %s", tr.currentSession.Name)
@@ -91,11 +112,92 @@ func (tr *TestRunner) Report(start time.Time, success bool) {
 	session := tr.currentSession
 	tr.mutex.RUnlock()
 
-	if session != nil && session.Status == StatusRunning {
+	if session != nil && session.running() {
 		session.Report(start, success)
 	}
 }
 
+// ReportWithLabels reports a labeled test result to the current session
+func (tr *TestRunner) ReportWithLabels(start time.Time, success bool, labels map[string]string) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil && session.running() {
+		session.ReportWithLabels(start, success, labels)
+	}
+}
+
+// ReportError reports a test result to the current session; a nil error is a success
+func (tr *TestRunner) ReportError(start time.Time, err error) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil && session.running() {
+		session.ReportError(start, err)
+	}
+}
+
+// SetErrorClassifier sets the classifier used by ReportError for sessions started afterwards
+func (tr *TestRunner) SetErrorClassifier(classifier ErrorClassifier) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.config.ErrorClassifier = classifier
+}
+
+// SetCollectorConfig sets how data collectors of sessions started afterwards handle load
+func (tr *TestRunner) SetCollectorConfig(config CollectorConfig) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.config.Collector = config
+}
+
+// SetPercentiles sets the response time percentiles (0 to 100) computed and
+// charted for sessions started afterwards
+func (tr *TestRunner) SetPercentiles(percentiles ...float64) {
+	sorted := append([]float64(nil), percentiles...)
+	sort.Float64s(sorted)
+
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.config.Percentiles = sorted
+}
+
+// SetSessionConfig sets the configuration of sessions started afterwards with StartTest
+func (tr *TestRunner) SetSessionConfig(config SessionConfig) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.config = config
+}
+
+// ReportDuration reports a test result with an explicitly measured response time
+func (tr *TestRunner) ReportDuration(start time.Time, elapsed time.Duration, success bool) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil && session.running() {
+		session.ReportDuration(start, elapsed, success)
+	}
+}
+
+// ReportTrip reports a test result with its own start and end time
+func (tr *TestRunner) ReportTrip(trip *Trip) {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session != nil && session.running() {
+		session.ReportTrip(trip)
+	}
+}
+
+// SetDisplayUnit sets the unit response times are displayed in on the dashboard
+func (tr *TestRunner) SetDisplayUnit(unit TimeUnit) {
+	tr.webViewer.SetDisplayUnit(unit)
+}
+
 // GetCurrentSession returns the current active session
 func (tr *TestRunner) GetCurrentSession() *TestSession {
 	tr.mutex.RLock()
@@ -0,0 +1,285 @@
+package ptest
+
+import (
+	"math"
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockTicks is the unit of the CPU times in /proc/self/stat; USER_HZ is
+// 100 on every common Linux platform
+const clockTicks = 100
+
+// runtime/metrics names read by the sampler
+const (
+	metricGoroutines  = "/sched/goroutines:goroutines"
+	metricHeapObjects = "/memory/classes/heap/objects:bytes"
+	metricHeapUnused  = "/memory/classes/heap/unused:bytes"
+	metricGCCycles    = "/gc/cycles/total:gc-cycles"
+	metricGCPauses    = "/sched/pauses/total/gc:seconds"
+)
+
+// RuntimeStat describes the health of the load generator process during a period
+type RuntimeStat struct {
+	Goroutines uint64 `json:"goroutines"`
+	// HeapInUse is the heap memory held by the Go runtime in bytes
+	HeapInUse uint64 `json:"heap_in_use"`
+	// RSS is the resident memory of the process in bytes; zero where
+	// /proc/self is not available
+	RSS uint64 `json:"rss"`
+	// CPUPercent is the process CPU usage, 100 per fully used core
+	CPUPercent float64 `json:"cpu_percent"`
+
+	// GCCycles counts the garbage collections finished during the period
+	GCCycles uint64 `json:"gc_cycles"`
+	// GCPauseP50, GCPauseP99 and GCPauseMax describe the stop-the-world
+	// pauses of the period in milliseconds
+	GCPauseP50 float64 `json:"gc_pause_p50"`
+	GCPauseP99 float64 `json:"gc_pause_p99"`
+	GCPauseMax float64 `json:"gc_pause_max"`
+
+	// GCPauses holds the pause distribution so periods can be merged
+	GCPauses *Histogram `json:"-"`
+}
+
+// withoutHistogram returns a copy of the stat that does not keep the pause
+// histogram alive
+func (r *RuntimeStat) withoutHistogram() *RuntimeStat {
+	if r == nil {
+		return nil
+	}
+
+	copied := *r
+	copied.GCPauses = nil
+	return &copied
+}
+
+// setGCPauseStats fills the pause fields from the pause histogram
+func (r *RuntimeStat) setGCPauseStats() {
+	if r.GCPauses == nil || r.GCPauses.Count() == 0 {
+		return
+	}
+
+	r.GCPauseP50 = durationToMillis(r.GCPauses.Percentile(50))
+	r.GCPauseP99 = durationToMillis(r.GCPauses.Percentile(99))
+	r.GCPauseMax = durationToMillis(r.GCPauses.Max())
+}
+
+// runtimeSampler reads runtime and process metrics and turns the cumulative
+// ones into per-interval values. Only the collector's run loop uses it.
+type runtimeSampler struct {
+	samples   []metrics.Sample
+	precision int
+
+	lastTime   time.Time
+	lastCPU    float64
+	lastCycles uint64
+	lastPauses []uint64
+}
+
+// newRuntimeSampler creates a sampler and takes its baseline reading
+func newRuntimeSampler(precision int) *runtimeSampler {
+	rs := &runtimeSampler{
+		samples: []metrics.Sample{
+			{Name: metricGoroutines},
+			{Name: metricHeapObjects},
+			{Name: metricHeapUnused},
+			{Name: metricGCCycles},
+			{Name: metricGCPauses},
+		},
+		precision: precision,
+	}
+	rs.sample()
+	return rs
+}
+
+// sample reads all metrics and returns their values since the previous sample
+func (rs *runtimeSampler) sample() *RuntimeStat {
+	now := time.Now()
+	first := rs.lastTime.IsZero()
+	metrics.Read(rs.samples)
+
+	stat := &RuntimeStat{
+		Goroutines: uint64Value(rs.samples[0]),
+		HeapInUse:  uint64Value(rs.samples[1]) + uint64Value(rs.samples[2]),
+		RSS:        processRSS(),
+	}
+
+	cycles := uint64Value(rs.samples[3])
+	if !first {
+		stat.GCCycles = cycles - rs.lastCycles
+	}
+	rs.lastCycles = cycles
+
+	cpu := processCPUSeconds()
+	if elapsed := now.Sub(rs.lastTime).Seconds(); !first && elapsed > 0 && cpu >= rs.lastCPU {
+		stat.CPUPercent = (cpu - rs.lastCPU) / elapsed * 100
+	}
+	rs.lastCPU = cpu
+	rs.lastTime = now
+
+	stat.GCPauses = rs.pausesSince(float64HistogramValue(rs.samples[4]), first)
+	stat.setGCPauseStats()
+
+	return stat
+}
+
+// pausesSince converts the pauses recorded since the previous sample into a
+// histogram, using the middle of each runtime histogram bucket
+func (rs *runtimeSampler) pausesSince(pauses *metrics.Float64Histogram, first bool) *Histogram {
+	if pauses == nil {
+		return nil
+	}
+
+	if len(rs.lastPauses) != len(pauses.Counts) {
+		rs.lastPauses = make([]uint64, len(pauses.Counts))
+	}
+
+	histogram := NewHistogram(rs.precision)
+	for i, count := range pauses.Counts {
+		delta := count - rs.lastPauses[i]
+		rs.lastPauses[i] = count
+		if first || delta == 0 {
+			continue
+		}
+
+		low, high := pauses.Buckets[i], pauses.Buckets[i+1]
+		if math.IsInf(low, -1) {
+			low = high
+		}
+		if math.IsInf(high, 1) {
+			high = low
+		}
+		histogram.RecordN(time.Duration((low+high)/2*float64(time.Second)), int64(delta))
+	}
+	return histogram
+}
+
+// uint64Value returns the value of a runtime metric, or zero if the runtime
+// does not support it
+func uint64Value(sample metrics.Sample) uint64 {
+	if sample.Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return sample.Value.Uint64()
+}
+
+// float64HistogramValue returns the histogram of a runtime metric, or nil if
+// the runtime does not support it
+func float64HistogramValue(sample metrics.Sample) *metrics.Float64Histogram {
+	if sample.Value.Kind() != metrics.KindFloat64Histogram {
+		return nil
+	}
+	return sample.Value.Float64Histogram()
+}
+
+// processCPUSeconds returns the user and system CPU time of the process from
+// /proc/self/stat, or zero if it cannot be read
+func processCPUSeconds() float64 {
+	data, err := os.ReadFile("/proc/self/stat")
+	if err != nil {
+		return 0
+	}
+	return parseCPUSeconds(string(data))
+}
+
+// parseCPUSeconds returns the user and system CPU time from a line in the
+// format of /proc/[pid]/stat, or zero if it is malformed
+func parseCPUSeconds(line string) float64 {
+	// The command name may contain spaces, so fields are counted from its end
+	end := strings.LastIndexByte(line, ')')
+	if end < 0 {
+		return 0
+	}
+	fields := strings.Fields(line[end+1:])
+	if len(fields) < 13 {
+		return 0
+	}
+
+	// utime and stime are the 14th and 15th fields of the whole line
+	utime, err := strconv.ParseFloat(fields[11], 64)
+	if err != nil {
+		return 0
+	}
+	stime, err := strconv.ParseFloat(fields[12], 64)
+	if err != nil {
+		return 0
+	}
+	return (utime + stime) / clockTicks
+}
+
+// processRSS returns the resident memory of the process from
+// /proc/self/statm, or zero if it cannot be read
+func processRSS() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// sampleRuntime records the runtime metrics of an interval that just ended
+func (dc *DataCollector) sampleRuntime(index int64) {
+	if dc.runtime == nil {
+		return
+	}
+
+	dc.runtimeStats[index] = dc.runtime.sample()
+}
+
+// setRuntime attaches the runtime metrics of its interval to a bucket about
+// to be published
+func (dc *DataCollector) setRuntime(index int64, bucket *TripsOfSec) {
+	if stat, ok := dc.runtimeStats[index]; ok {
+		bucket.Runtime = stat
+		delete(dc.runtimeStats, index)
+	}
+}
+
+// aggregateRuntime aggregates the runtime metrics of one tier window: gauges
+// keep their last value, CPU usage is averaged and GC pauses are merged
+func aggregateRuntime(stats []*Stat) *RuntimeStat {
+	var aggregated *RuntimeStat
+	var cpuMs, coveredMs float64
+
+	for _, stat := range stats {
+		if stat.Runtime == nil {
+			continue
+		}
+		if aggregated == nil {
+			aggregated = &RuntimeStat{}
+		}
+
+		aggregated.Goroutines = stat.Runtime.Goroutines
+		aggregated.HeapInUse = stat.Runtime.HeapInUse
+		aggregated.RSS = stat.Runtime.RSS
+		aggregated.GCCycles += stat.Runtime.GCCycles
+		aggregated.GCPauses = mergeHistogram(aggregated.GCPauses, stat.Runtime.GCPauses)
+
+		cpuMs += stat.Runtime.CPUPercent * float64(stat.IntervalMs)
+		coveredMs += float64(stat.IntervalMs)
+	}
+
+	if aggregated == nil {
+		return nil
+	}
+
+	if coveredMs > 0 {
+		aggregated.CPUPercent = cpuMs / coveredMs
+	}
+	aggregated.setGCPauseStats()
+	return aggregated
+}
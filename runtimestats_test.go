@@ -0,0 +1,115 @@
+package ptest
+
+import (
+	"math"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestPausesSince(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0.001, 0.002, math.Inf(1)}
+
+	// Each step is the runtime's cumulative pause counts at one sample
+	tests := []struct {
+		name     string
+		counts   []uint64
+		wantN    int64
+		min, max time.Duration
+	}{
+		{"baseline ignores earlier pauses", []uint64{0, 2, 0}, 0, 0, 0},
+		{"unchanged", []uint64{0, 2, 0}, 0, 0, 0},
+		// The open lower bucket counts at its upper bound
+		{"new pauses only", []uint64{1, 3, 0}, 2, time.Millisecond, 1500 * time.Microsecond},
+		// The open upper bucket counts at its lower bound
+		{"open upper bucket", []uint64{1, 3, 2}, 2, 2 * time.Millisecond, 2 * time.Millisecond},
+	}
+
+	rs := &runtimeSampler{precision: DefaultHistogramPrecision}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pauses := &metrics.Float64Histogram{Counts: tt.counts, Buckets: buckets}
+			histogram := rs.pausesSince(pauses, i == 0)
+
+			if got := histogram.Count(); got != tt.wantN {
+				t.Fatalf("recorded %d pauses, want %d", got, tt.wantN)
+			}
+			if tt.wantN > 0 && (histogram.Min() != tt.min || histogram.Max() != tt.max) {
+				t.Errorf("pauses from %v to %v, want %v to %v", histogram.Min(), histogram.Max(), tt.min, tt.max)
+			}
+		})
+	}
+
+	if rs.pausesSince(nil, false) != nil {
+		t.Error("pausesSince(nil) recorded pauses without a runtime histogram")
+	}
+}
+
+func TestAggregateRuntime(t *testing.T) {
+	runtimeStat := func(goroutines uint64, cpu float64, cycles uint64) *RuntimeStat {
+		return &RuntimeStat{Goroutines: goroutines, CPUPercent: cpu, GCCycles: cycles}
+	}
+
+	tests := []struct {
+		name  string
+		stats []*Stat
+		want  *RuntimeStat
+	}{
+		{"none", []*Stat{{IntervalMs: 1000}}, nil},
+		{"one", []*Stat{{IntervalMs: 1000, Runtime: runtimeStat(10, 50, 1)}}, runtimeStat(10, 50, 1)},
+		// A three times longer interval weighs three times as much
+		{"weighted by interval", []*Stat{
+			{IntervalMs: 1000, Runtime: runtimeStat(10, 50, 1)},
+			{IntervalMs: 3000, Runtime: runtimeStat(20, 100, 2)},
+		}, runtimeStat(20, 87.5, 3)},
+		// Intervals without runtime metrics do not dilute the CPU usage
+		{"missing runtime", []*Stat{
+			{IntervalMs: 1000, Runtime: runtimeStat(10, 40, 1)},
+			{IntervalMs: 1000},
+			{IntervalMs: 1000, Runtime: runtimeStat(30, 60, 0)},
+		}, runtimeStat(30, 50, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := aggregateRuntime(tt.stats)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("aggregateRuntime() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("aggregateRuntime() = nil")
+			}
+			if got.Goroutines != tt.want.Goroutines || got.GCCycles != tt.want.GCCycles {
+				t.Errorf("goroutines %d, GC cycles %d, want %d and %d", got.Goroutines, got.GCCycles, tt.want.Goroutines, tt.want.GCCycles)
+			}
+			if math.Abs(got.CPUPercent-tt.want.CPUPercent) > 1e-9 {
+				t.Errorf("CPU usage %v%%, want %v%%", got.CPUPercent, tt.want.CPUPercent)
+			}
+		})
+	}
+}
+
+func TestParseCPUSeconds(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want float64
+	}{
+		{"plain", "42 (ptest) S 1 42 42 0 -1 4194560 1 0 0 0 250 50 0 0 20 0 8 0 100 0 0\n", 3},
+		{"command with spaces and parentheses", "42 (my (load) test) R 1 42 42 0 -1 4194560 1 0 0 0 100 25 0 0\n", 1.25},
+		{"no command", "42 ptest S 1 42 42 0 -1 4194560 1 0 0 0 250 50\n", 0},
+		{"truncated", "42 (ptest) S 1 42 42 0 -1 4194560 1 0 0 0 250\n", 0},
+		{"not a number", "42 (ptest) S 1 42 42 0 -1 4194560 1 0 0 0 x 50 0 0\n", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseCPUSeconds(tt.line); got != tt.want {
+				t.Errorf("parseCPUSeconds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package ptest
 
 import (
+	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,13 +17,174 @@ const (
 	StatusStopped SessionStatus = "stopped"
 )
 
-// CumulativeStats holds cumulative statistics for weighted averaging
+// CumulativeStats holds cumulative statistics for weighted averaging.
+// Response time totals are in milliseconds.
 type CumulativeStats struct {
 	TotalSuccessRT float64
 	TotalFailureRT float64
 	TotalSuccess   int64
 	TotalFailure   int64
-	mutex          sync.RWMutex
+	PeakInFlight   int64
+	// Responses adds up the status codes and sizes of requests with a response
+	Responses *ResponseStat
+	mutex     sync.RWMutex
+}
+
+// add adds a stat to the cumulative totals
+func (cs *CumulativeStats) add(stat *Stat) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	// Add weighted response times (response_time * count)
+	if stat.SuccessCount > 0 {
+		cs.TotalSuccessRT += stat.ResponseTime * float64(stat.SuccessCount)
+		cs.TotalSuccess += int64(stat.SuccessCount)
+	}
+
+	if stat.FailureCount > 0 {
+		cs.TotalFailureRT += stat.FailureResponseTime * float64(stat.FailureCount)
+		cs.TotalFailure += int64(stat.FailureCount)
+	}
+
+	if stat.PeakInFlight > cs.PeakInFlight {
+		cs.PeakInFlight = stat.PeakInFlight
+	}
+
+	cs.Responses = mergeResponseStat(cs.Responses, stat.Responses)
+}
+
+// reset clears the cumulative totals
+func (cs *CumulativeStats) reset() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.TotalSuccessRT = 0
+	cs.TotalFailureRT = 0
+	cs.TotalSuccess = 0
+	cs.TotalFailure = 0
+	cs.PeakInFlight = 0
+	cs.Responses = nil
+}
+
+// totalRequests returns the number of requests in the totals
+func (cs *CumulativeStats) totalRequests() int64 {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.TotalSuccess + cs.TotalFailure
+}
+
+// peakInFlight returns the most requests in flight at any moment
+func (cs *CumulativeStats) peakInFlight() int64 {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.PeakInFlight
+}
+
+// responses returns a copy of the response totals, nil without any
+func (cs *CumulativeStats) responses() *ResponseStat {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+	return cs.Responses.copy()
+}
+
+// avgResponseTime returns the weighted average response time
+func (cs *CumulativeStats) avgResponseTime() float64 {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	totalRequests := cs.TotalSuccess + cs.TotalFailure
+	if totalRequests == 0 {
+		return 0
+	}
+
+	totalWeightedRT := cs.TotalSuccessRT + cs.TotalFailureRT
+	return totalWeightedRT / float64(totalRequests)
+}
+
+// errorRate returns the error rate in percent
+func (cs *CumulativeStats) errorRate() float64 {
+	cs.mutex.RLock()
+	defer cs.mutex.RUnlock()
+
+	totalRequests := cs.TotalSuccess + cs.TotalFailure
+	if totalRequests == 0 {
+		return 0
+	}
+
+	return float64(cs.TotalFailure) / float64(totalRequests) * 100
+}
+
+// labelCumulativeStats holds cumulative statistics for one label set
+type labelCumulativeStats struct {
+	labels map[string]string
+	stats  *CumulativeStats
+}
+
+// SessionConfig configures a test session
+type SessionConfig struct {
+	// Interval is the width of the aggregation buckets, one second by
+	// default; a whole number of milliseconds
+	Interval time.Duration
+	// Tiers is the chart resolution ladder; nil derives DefaultChartTiers(Interval).
+	// Tier intervals are multiples of Interval.
+	Tiers []ChartTier
+	// Percentiles are the response time percentiles computed and charted
+	Percentiles []float64
+	// Collector configures how reports are collected under load
+	Collector CollectorConfig
+	// ErrorClassifier classifies the errors passed to ReportError
+	ErrorClassifier ErrorClassifier
+}
+
+// DefaultSessionConfig returns the configuration used when none is set
+func DefaultSessionConfig() SessionConfig {
+	return SessionConfig{
+		Interval:        DefaultInterval,
+		Percentiles:     DefaultPercentiles,
+		Collector:       DefaultCollectorConfig(),
+		ErrorClassifier: DefaultErrorClassifier,
+	}
+}
+
+// Validate reports an interval that is not a whole number of milliseconds,
+// or a tier interval that is not a multiple of the session interval. Sessions
+// started with such a configuration round the intervals up.
+func (c SessionConfig) Validate() error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if interval%time.Millisecond != 0 {
+		return fmt.Errorf("ptest: interval %v is not a whole number of milliseconds", interval)
+	}
+
+	for _, tier := range c.Tiers {
+		if tier.Interval%interval != 0 {
+			return fmt.Errorf("ptest: interval %v of chart tier %q is not a multiple of the session interval %v", tier.Interval, tier.Name, interval)
+		}
+	}
+	return nil
+}
+
+// withDefaults fills unset fields with their defaults and rounds the
+// interval up to whole milliseconds
+func (c SessionConfig) withDefaults() SessionConfig {
+	if c.Interval <= 0 {
+		c.Interval = DefaultInterval
+	}
+	if remainder := c.Interval % time.Millisecond; remainder != 0 {
+		c.Interval += time.Millisecond - remainder
+	}
+	if len(c.Tiers) == 0 {
+		c.Tiers = DefaultChartTiers(c.Interval)
+	}
+	if c.Percentiles == nil {
+		c.Percentiles = DefaultPercentiles
+	}
+	if c.ErrorClassifier == nil {
+		c.ErrorClassifier = DefaultErrorClassifier
+	}
+	return c
 }
 
 // TestSession represents a single test execution session
@@ -37,26 +201,72 @@ type TestSession struct {
 
 	// Cumulative statistics for accurate averaging
 	cumulativeStats *CumulativeStats
+	labelStats      map[string]*labelCumulativeStats
+	labelMutex      sync.RWMutex
+
+	// Latency distributions over the whole session, summarized with the
+	// configured percentiles
+	percentiles      []float64
+	successHistogram *Histogram
+	failureHistogram *Histogram
+	histogramMutex   sync.RWMutex
+
+	// Latency distributions corrected for coordinated omission, kept once
+	// the first trip with an intended start time arrives
+	correcting                bool
+	correctedSuccessHistogram *Histogram
+	correctedFailureHistogram *Histogram
+
+	// Error classes seen during the session
+	errorClasses *errorClassTable
+	errorMutex   sync.RWMutex
+
+	// Steps of every transaction in the order they first ran; stepsVersion
+	// counts the steps registered
+	transactionSteps  map[string][]string
+	stepsVersion      uint64
+	transactionMutex  sync.RWMutex
+	transactionCharts transactionChartCache
+
+	// Timeline annotations such as load profile stages
+	annotations     []Annotation
+	annotationMutex sync.RWMutex
 
 	statsChan chan *Stat
-	mutex     sync.RWMutex
+	// stopped is closed when the session stops and processed once all its
+	// stats went through the pipeline
+	stopped   chan struct{}
+	processed chan struct{}
+	// active mirrors Status == StatusRunning for readers on report paths,
+	// which must not race with start and stop
+	active atomic.Bool
+	mutex  sync.RWMutex
 }
 
 // newTestSession creates a new test session
-func newTestSession(id, name string) *TestSession {
+func newTestSession(id, name string, config SessionConfig) *TestSession {
+	config = config.withDefaults()
+
 	session := &TestSession{
-		ID:              id,
-		Name:            name,
-		StartTime:       time.Now(),
-		Status:          StatusIdle,
-		statsChan:       make(chan *Stat, 1000),
-		cumulativeStats: &CumulativeStats{},
-		mutex:           sync.RWMutex{},
+		ID:               id,
+		Name:             name,
+		StartTime:        time.Now(),
+		Status:           StatusIdle,
+		statsChan:        make(chan *Stat, 1000),
+		stopped:          make(chan struct{}),
+		processed:        make(chan struct{}),
+		cumulativeStats:  &CumulativeStats{},
+		labelStats:       make(map[string]*labelCumulativeStats),
+		percentiles:      config.Percentiles,
+		errorClasses:     newErrorClassTable(),
+		transactionSteps: make(map[string][]string),
+		mutex:            sync.RWMutex{},
 	}
 
-	session.dataCollector = newDataCollector()
-	session.aggregator = newDataAggregator()
-	session.chartManager = newChartDataManager()
+	session.dataCollector = newDataCollector(config.Collector, config.Interval)
+	session.dataCollector.classifier = config.ErrorClassifier
+	session.aggregator = newDataAggregator(config.Percentiles, session.dataCollector.config.Backpressure)
+	session.chartManager = newChartDataManager(config.Interval, config.Tiers, config.Percentiles)
 
 	return session
 }
@@ -71,16 +281,28 @@ func (ts *TestSession) start() {
 	}
 
 	ts.Status = StatusRunning
+	ts.active.Store(true)
 	ts.StartTime = time.Now()
 	ts.EndTime = nil
 
 	// Reset cumulative stats for new session
-	ts.cumulativeStats.mutex.Lock()
-	ts.cumulativeStats.TotalSuccessRT = 0
-	ts.cumulativeStats.TotalFailureRT = 0
-	ts.cumulativeStats.TotalSuccess = 0
-	ts.cumulativeStats.TotalFailure = 0
-	ts.cumulativeStats.mutex.Unlock()
+	ts.cumulativeStats.reset()
+	ts.labelMutex.Lock()
+	ts.labelStats = make(map[string]*labelCumulativeStats)
+	ts.labelMutex.Unlock()
+	ts.errorMutex.Lock()
+	ts.errorClasses = newErrorClassTable()
+	ts.errorMutex.Unlock()
+	ts.annotationMutex.Lock()
+	ts.annotations = nil
+	ts.annotationMutex.Unlock()
+	ts.histogramMutex.Lock()
+	ts.successHistogram = nil
+	ts.failureHistogram = nil
+	ts.correcting = false
+	ts.correctedSuccessHistogram = nil
+	ts.correctedFailureHistogram = nil
+	ts.histogramMutex.Unlock()
 
 	// Start data processing pipeline
 	go ts.processData()
@@ -96,24 +318,65 @@ func (ts *TestSession) stop() {
 	}
 
 	ts.Status = StatusStopped
+	ts.active.Store(false)
 	now := time.Now()
 	ts.EndTime = &now
 
-	// Stop data collector
+	// Stop data collector and load drivers
 	ts.dataCollector.Stop()
+	close(ts.stopped)
 }
 
 // Report reports a test result
 func (ts *TestSession) Report(start time.Time, success bool) {
-	if ts.Status != StatusRunning {
+	if !ts.running() {
 		return
 	}
 
 	ts.dataCollector.Report(start, success)
 }
 
+// ReportWithLabels reports a labeled test result
+func (ts *TestSession) ReportWithLabels(start time.Time, success bool, labels map[string]string) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.ReportWithLabels(start, success, labels)
+}
+
+// ReportError reports a test result; a nil error is a success and any other
+// error is a failure classified by the session's error classifier
+func (ts *TestSession) ReportError(start time.Time, err error) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.ReportError(start, err)
+}
+
+// ReportDuration reports a test result with an explicitly measured response time
+func (ts *TestSession) ReportDuration(start time.Time, elapsed time.Duration, success bool) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.ReportDuration(start, elapsed, success)
+}
+
+// ReportTrip reports a test result with its own start and end time
+func (ts *TestSession) ReportTrip(trip *Trip) {
+	if !ts.running() {
+		return
+	}
+
+	ts.dataCollector.ReportTrip(trip)
+}
+
 // processData processes collected data through the pipeline
 func (ts *TestSession) processData() {
+	defer close(ts.processed)
+
 	// Connect data collector -> aggregator -> chart manager
 	go ts.aggregator.Process(ts.dataCollector.ResultChan, ts.statsChan)
 
@@ -126,46 +389,129 @@ func (ts *TestSession) processData() {
 
 // updateCumulativeStats updates cumulative statistics for weighted averaging
 func (ts *TestSession) updateCumulativeStats(stat *Stat) {
-	ts.cumulativeStats.mutex.Lock()
-	defer ts.cumulativeStats.mutex.Unlock()
+	ts.cumulativeStats.add(stat)
+
+	ts.histogramMutex.Lock()
+	ts.addCorrectedHistograms(stat)
+	// Sampled stats count every recorded trip for the ones left out
+	ts.successHistogram = mergeHistogramWeighted(ts.successHistogram, stat.SuccessHistogram, stat.sampleEvery)
+	ts.failureHistogram = mergeHistogramWeighted(ts.failureHistogram, stat.FailureHistogram, stat.sampleEvery)
+	ts.histogramMutex.Unlock()
+
+	if len(stat.ErrorClasses) > 0 {
+		ts.errorMutex.Lock()
+		ts.errorClasses.add(stat)
+		ts.errorMutex.Unlock()
+	}
 
-	// Add weighted response times (response_time * count)
-	if stat.SuccessCount > 0 && stat.ResponseTime > 0 {
-		ts.cumulativeStats.TotalSuccessRT += stat.ResponseTime * float64(stat.SuccessCount)
-		ts.cumulativeStats.TotalSuccess += int64(stat.SuccessCount)
+	if len(stat.Labeled) == 0 {
+		return
 	}
 
-	if stat.FailureCount > 0 && stat.FailureResponseTime > 0 {
-		ts.cumulativeStats.TotalFailureRT += stat.FailureResponseTime * float64(stat.FailureCount)
-		ts.cumulativeStats.TotalFailure += int64(stat.FailureCount)
+	ts.labelMutex.Lock()
+	defer ts.labelMutex.Unlock()
+
+	for key, labeled := range stat.Labeled {
+		entry, ok := ts.labelStats[key]
+		if !ok {
+			entry = &labelCumulativeStats{
+				labels: labeled.Labels,
+				stats:  &CumulativeStats{},
+			}
+			ts.labelStats[key] = entry
+		}
+		entry.stats.add(labeled)
 	}
 }
 
-// GetCumulativeAvgResponseTime returns overall weighted average response time
-func (ts *TestSession) GetCumulativeAvgResponseTime() float64 {
-	ts.cumulativeStats.mutex.RLock()
-	defer ts.cumulativeStats.mutex.RUnlock()
+// addCorrectedHistograms adds a stat to the corrected latency distributions.
+// They start as copies of the raw ones, as trips without an intended start
+// time need no correction. The histogram lock must be held.
+func (ts *TestSession) addCorrectedHistograms(stat *Stat) {
+	if stat.Corrected != nil && !ts.correcting {
+		ts.correcting = true
+		ts.correctedSuccessHistogram = mergeHistogram(nil, ts.successHistogram)
+		ts.correctedFailureHistogram = mergeHistogram(nil, ts.failureHistogram)
+	}
+	if !ts.correcting {
+		return
+	}
 
-	totalRequests := ts.cumulativeStats.TotalSuccess + ts.cumulativeStats.TotalFailure
-	if totalRequests == 0 {
-		return 0
+	success, failure := stat.SuccessHistogram, stat.FailureHistogram
+	if stat.Corrected != nil {
+		success, failure = stat.Corrected.SuccessHistogram, stat.Corrected.FailureHistogram
 	}
+	ts.correctedSuccessHistogram = mergeHistogramWeighted(ts.correctedSuccessHistogram, success, stat.sampleEvery)
+	ts.correctedFailureHistogram = mergeHistogramWeighted(ts.correctedFailureHistogram, failure, stat.sampleEvery)
+}
 
-	totalWeightedRT := ts.cumulativeStats.TotalSuccessRT + ts.cumulativeStats.TotalFailureRT
-	return totalWeightedRT / float64(totalRequests)
+// GetCumulativeAvgResponseTime returns overall weighted average response time
+func (ts *TestSession) GetCumulativeAvgResponseTime() float64 {
+	return ts.cumulativeStats.avgResponseTime()
 }
 
 // GetCumulativeErrorRate returns overall error rate
 func (ts *TestSession) GetCumulativeErrorRate() float64 {
-	ts.cumulativeStats.mutex.RLock()
-	defer ts.cumulativeStats.mutex.RUnlock()
+	return ts.cumulativeStats.errorRate()
+}
 
-	totalRequests := ts.cumulativeStats.TotalSuccess + ts.cumulativeStats.TotalFailure
-	if totalRequests == 0 {
-		return 0
+// GetLabelStats returns cumulative statistics for every label set, sorted by key
+func (ts *TestSession) GetLabelStats() []*LabelStats {
+	var current *Stat
+	if ts.aggregator != nil {
+		current = ts.aggregator.GetCurrentStat()
 	}
 
-	return float64(ts.cumulativeStats.TotalFailure) / float64(totalRequests) * 100
+	ts.labelMutex.RLock()
+	defer ts.labelMutex.RUnlock()
+
+	result := make([]*LabelStats, 0, len(ts.labelStats))
+	for key, entry := range ts.labelStats {
+		labelStats := &LabelStats{
+			Key:                 key,
+			Labels:              entry.labels,
+			TotalRequests:       entry.stats.totalRequests(),
+			CumulativeAvgRT:     entry.stats.avgResponseTime(),
+			CumulativeErrorRate: entry.stats.errorRate(),
+			Responses:           entry.stats.responses(),
+		}
+		if current != nil {
+			labelStats.CurrentStat = current.Labeled[key]
+		}
+		result = append(result, labelStats)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// GetLatencySummaries returns the success and failure latency distributions
+// of the whole session; either is nil if there were no such requests
+func (ts *TestSession) GetLatencySummaries() (*LatencySummary, *LatencySummary) {
+	ts.histogramMutex.RLock()
+	defer ts.histogramMutex.RUnlock()
+	return ts.successHistogram.Summary(ts.percentiles), ts.failureHistogram.Summary(ts.percentiles)
+}
+
+// GetCorrectedLatencySummaries returns the success and failure latency
+// distributions of the whole session corrected for coordinated omission;
+// both are nil if no trip had an intended start time
+func (ts *TestSession) GetCorrectedLatencySummaries() (*LatencySummary, *LatencySummary) {
+	ts.histogramMutex.RLock()
+	defer ts.histogramMutex.RUnlock()
+	return ts.correctedSuccessHistogram.Summary(ts.percentiles), ts.correctedFailureHistogram.Summary(ts.percentiles)
+}
+
+// GetErrorClasses returns the error classes seen during the session, most frequent first
+func (ts *TestSession) GetErrorClasses() []*ErrorClassStats {
+	ts.errorMutex.RLock()
+	defer ts.errorMutex.RUnlock()
+	return ts.errorClasses.list()
+}
+
+// GetLabeledChartData returns optimized chart data for one label set
+func (ts *TestSession) GetLabeledChartData(key string) *ChartData {
+	return ts.chartManager.GetLabeledData(key)
 }
 
 // GetOptimizedChartData returns optimized chart data
@@ -188,8 +534,19 @@ func (ts *TestSession) GetStats() *SessionStats {
 		TotalRequests:       ts.dataCollector.GetTotalRequests(),
 		CumulativeAvgRT:     ts.GetCumulativeAvgResponseTime(),
 		CumulativeErrorRate: ts.GetCumulativeErrorRate(),
+		Labels:              ts.GetLabelStats(),
+		ErrorClasses:        ts.GetErrorClasses(),
+		Transactions:        ts.GetTransactionStats(),
+		Drops:               ts.getDropStats(),
+		InFlight:            ts.dataCollector.GetInFlight(),
+		PeakInFlight:        ts.cumulativeStats.peakInFlight(),
+		MissedIterations:    ts.dataCollector.GetMissedIterations(),
+		Annotations:         ts.GetAnnotations(),
 	}
 
+	stats.SuccessLatency, stats.FailureLatency = ts.GetLatencySummaries()
+	stats.CorrectedSuccessLatency, stats.CorrectedFailureLatency = ts.GetCorrectedLatencySummaries()
+
 	if ts.aggregator != nil {
 		stats.CurrentStat = ts.aggregator.GetCurrentStat()
 	}
@@ -197,7 +554,22 @@ func (ts *TestSession) GetStats() *SessionStats {
 	return stats
 }
 
-// getDuration calculates session duration
+// getDropStats returns how much data was lost to backpressure anywhere in the pipeline
+func (ts *TestSession) getDropStats() *DropStats {
+	drops := ts.dataCollector.GetDropStats()
+	stats, trips := ts.aggregator.dropped()
+	drops.DroppedIntervals += stats
+	drops.DroppedIntervalTrips += trips
+	return drops
+}
+
+// running reports whether the session is running; unlike Status it is safe
+// to read while the session starts or stops
+func (ts *TestSession) running() bool {
+	return ts.active.Load()
+}
+
+// getDuration calculates session duration. The session lock must be held.
 func (ts *TestSession) getDuration() time.Duration {
 	if ts.EndTime != nil {
 		return ts.EndTime.Sub(ts.StartTime)
@@ -210,14 +582,39 @@ func (ts *TestSession) getDuration() time.Duration {
 
 // SessionStats contains session statistics
 type SessionStats struct {
-	SessionID           string        `json:"session_id"`
-	SessionName         string        `json:"session_name"`
-	Status              SessionStatus `json:"status"`
-	StartTime           time.Time     `json:"start_time"`
-	EndTime             *time.Time    `json:"end_time,omitempty"`
-	Duration            time.Duration `json:"duration"`
-	TotalRequests       int64         `json:"total_requests"`
-	CumulativeAvgRT     float64       `json:"cumulative_avg_rt"`
-	CumulativeErrorRate float64       `json:"cumulative_error_rate"`
-	CurrentStat         *Stat         `json:"current_stat,omitempty"`
+	SessionID               string              `json:"session_id"`
+	SessionName             string              `json:"session_name"`
+	Status                  SessionStatus       `json:"status"`
+	StartTime               time.Time           `json:"start_time"`
+	EndTime                 *time.Time          `json:"end_time,omitempty"`
+	Duration                time.Duration       `json:"duration"`
+	TotalRequests           int64               `json:"total_requests"`
+	CumulativeAvgRT         float64             `json:"cumulative_avg_rt"`
+	CumulativeErrorRate     float64             `json:"cumulative_error_rate"`
+	CurrentStat             *Stat               `json:"current_stat,omitempty"`
+	SuccessLatency          *LatencySummary     `json:"success_latency,omitempty"`
+	FailureLatency          *LatencySummary     `json:"failure_latency,omitempty"`
+	CorrectedSuccessLatency *LatencySummary     `json:"corrected_success_latency,omitempty"`
+	CorrectedFailureLatency *LatencySummary     `json:"corrected_failure_latency,omitempty"`
+	Labels                  []*LabelStats       `json:"labels,omitempty"`
+	ErrorClasses            []*ErrorClassStats  `json:"error_classes,omitempty"`
+	Transactions            []*TransactionStats `json:"transactions,omitempty"`
+	Drops                   *DropStats          `json:"drops"`
+	InFlight                int64               `json:"in_flight"`
+	PeakInFlight            int64               `json:"peak_in_flight"`
+	MissedIterations        int64               `json:"missed_iterations"`
+	Annotations             []Annotation        `json:"annotations,omitempty"`
+}
+
+// LabelStats contains cumulative statistics for one label set
+type LabelStats struct {
+	Key                 string            `json:"key"`
+	Labels              map[string]string `json:"labels"`
+	TotalRequests       int64             `json:"total_requests"`
+	CumulativeAvgRT     float64           `json:"cumulative_avg_rt"`
+	CumulativeErrorRate float64           `json:"cumulative_error_rate"`
+	// Responses holds the status codes and sizes of the label set's
+	// requests with a response
+	Responses   *ResponseStat `json:"responses,omitempty"`
+	CurrentStat *Stat         `json:"current_stat,omitempty"`
 }
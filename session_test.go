@@ -0,0 +1,108 @@
+package ptest
+
+import (
+	"testing"
+	"time"
+)
+
+// runSession runs fn against a started session, stops it and waits until
+// all its stats are processed
+func runSession(t *testing.T, config SessionConfig, fn func(ts *TestSession)) *TestSession {
+	t.Helper()
+
+	ts := newTestSession("test", t.Name(), config)
+	ts.start()
+	fn(ts)
+	ts.stop()
+
+	select {
+	case <-ts.processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session stats were not processed")
+	}
+	return ts
+}
+
+func TestSessionLatencySummary(t *testing.T) {
+	const reports = 2000
+
+	tests := []struct {
+		name        string
+		collector   CollectorConfig
+		percentiles []float64
+		sampleEvery int64
+	}{
+		{"all trips", DefaultCollectorConfig(), nil, 1},
+		{"sampled", CollectorConfig{Backpressure: BackpressureSample, SampleRate: 0.1}, nil, 10},
+		{"custom percentiles", DefaultCollectorConfig(), []float64{75, 99.99}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := SessionConfig{Collector: tt.collector, Percentiles: tt.percentiles}
+			ts := runSession(t, config, func(ts *TestSession) {
+				for i := 0; i < reports; i++ {
+					ts.ReportDuration(time.Now(), time.Millisecond, true)
+				}
+			})
+
+			summary, _ := ts.GetLatencySummaries()
+			if summary == nil {
+				t.Fatal("no success latency summary")
+			}
+
+			// The summary counts what the cumulative totals count, with
+			// sampled trips scaled up
+			if total := ts.cumulativeStats.totalRequests(); summary.Count != total {
+				t.Errorf("summary counts %d trips, cumulative stats %d", summary.Count, total)
+			}
+			shards := int64(len(ts.dataCollector.shards))
+			if summary.Count > reports || summary.Count < reports-shards*tt.sampleEvery {
+				t.Errorf("summary counts %d trips, want about %d", summary.Count, reports)
+			}
+
+			percentiles := tt.percentiles
+			if percentiles == nil {
+				percentiles = DefaultPercentiles
+			}
+			if len(summary.Percentiles) != len(percentiles) {
+				t.Errorf("summary has percentiles %v, want %v", summary.Percentiles, percentiles)
+			}
+			for _, percentile := range percentiles {
+				if _, ok := summary.Percentiles[percentileKey(percentile)]; !ok {
+					t.Errorf("summary lacks %s", percentileKey(percentile))
+				}
+			}
+		})
+	}
+}
+
+func TestSessionConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SessionConfig
+		wantErr bool
+	}{
+		{"defaults", SessionConfig{}, false},
+		{"milliseconds", SessionConfig{Interval: 100 * time.Millisecond}, false},
+		{"sub-millisecond", SessionConfig{Interval: 500 * time.Microsecond}, true},
+		{"fractional milliseconds", SessionConfig{Interval: 1500 * time.Microsecond}, true},
+		{"tier multiple", SessionConfig{Interval: 100 * time.Millisecond, Tiers: []ChartTier{{Interval: time.Second}}}, false},
+		{"tier not a multiple", SessionConfig{Interval: 300 * time.Millisecond, Tiers: []ChartTier{{Interval: time.Second}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+
+			// Rounded up, every configuration is usable
+			config := tt.config.withDefaults()
+			if config.Interval%time.Millisecond != 0 || config.Interval < time.Millisecond {
+				t.Errorf("interval rounded to %v", config.Interval)
+			}
+		})
+	}
+}
@@ -2,10 +2,25 @@ class PerfTestDashboard {
     constructor() {
         this.ws = null;
         this.charts = {};
+        this.metricCharts = {};
+        this.transactionCharts = {};
+        this.showCorrected = false;
+        this.lastMessage = null;
+        this.annotations = [];
+        this.timeline = null;
         this.currentSession = null;
         this.maxDataPoints = 300;
+        this.displayUnit = 'ms';
+        this.percentiles = [90, 95, 99];
+        this.errorClassColors = {};
+        this.palette = [
+            'rgb(255, 99, 132)', 'rgb(255, 159, 64)', 'rgb(255, 206, 86)',
+            'rgb(153, 102, 255)', 'rgb(54, 162, 235)', 'rgb(201, 203, 207)',
+            'rgb(214, 51, 132)', 'rgb(32, 201, 151)'
+        ];
 
         this.initializeCharts();
+        this.initializeCorrectedToggle();
         this.connectWebSocket();
         this.startDurationTimer();
     }
@@ -75,6 +90,7 @@ class PerfTestDashboard {
 
     handleOptimizedData(messageData) {
         if (!messageData) return;
+        this.lastMessage = messageData;
 
         // Handle both old format (direct chart data) and new format (with session stats)
         let chartData, sessionStats;
@@ -83,11 +99,17 @@ class PerfTestDashboard {
             // New format with session stats
             chartData = messageData.chart_data;
             sessionStats = messageData.session_stats;
+            this.annotations = (sessionStats && sessionStats.annotations) || [];
+            this.setDisplayUnit(messageData.display_unit);
         } else {
             // Old format - direct chart data
             chartData = messageData;
         }
 
+        if (chartData.percentiles && chartData.percentiles.length > 0) {
+            this.percentiles = chartData.percentiles;
+        }
+
         // Use recent data for real-time updates
         const data = this.selectBestDataset(chartData);
 
@@ -101,6 +123,276 @@ class PerfTestDashboard {
                 this.updateRealTimeStats(data[data.length - 1]);
             }
         }
+
+        this.updateCorrectedToggle(data, sessionStats);
+
+        if (messageData.chart_data) {
+            this.updateTransactionCharts(messageData.transaction_charts || []);
+        }
+
+        if (sessionStats) {
+            this.updateTransactions(sessionStats.transactions);
+            this.updateLabelBreakdown(sessionStats.labels);
+            this.updateErrorClasses(sessionStats.error_classes);
+            this.updateDropWarning(sessionStats.drops);
+        }
+    }
+
+    updateDropWarning(drops) {
+        const banner = document.getElementById('dropWarning');
+        const messages = [];
+
+        if (drops && drops.dropped_intervals > 0) {
+            messages.push(`${drops.dropped_intervals.toLocaleString()} intervals (${drops.dropped_interval_trips.toLocaleString()} reports) were dropped before aggregation.`);
+        }
+        if (drops && drops.dropped_label_trips > 0) {
+            messages.push(`${drops.dropped_label_trips.toLocaleString()} reports had label sets beyond the limit and are only in the overall statistics.`);
+        }
+        if (drops && drops.dropped_metric_values > 0) {
+            messages.push(`${drops.dropped_metric_values.toLocaleString()} custom metric values were dropped (metric limit reached or name used with another kind).`);
+        }
+        if (drops && drops.sample_rate > 0 && drops.sample_rate < 1) {
+            messages.push(`Sampling ${(drops.sample_rate * 100).toFixed(1)}% of reports; counts are scaled estimates.`);
+        }
+
+        if (messages.length === 0) {
+            banner.style.display = 'none';
+            banner.textContent = '';
+            return;
+        }
+
+        const prefix = drops.dropped_intervals > 0
+            ? 'Warning: numbers undercount the real load. '
+            : '';
+        banner.textContent = prefix + messages.join(' ');
+        banner.style.display = 'block';
+    }
+
+    updateErrorClasses(errorClasses) {
+        const panel = document.getElementById('errorClasses');
+        const body = document.getElementById('errorClassesBody');
+
+        if (!errorClasses || errorClasses.length === 0) {
+            panel.style.display = 'none';
+            body.innerHTML = '';
+            return;
+        }
+
+        panel.style.display = 'block';
+        body.innerHTML = '';
+
+        const total = errorClasses.reduce((sum, entry) => sum + (entry.count || 0), 0);
+        errorClasses.forEach(entry => {
+            const cells = [
+                entry.class,
+                (entry.count || 0).toLocaleString(),
+                `${(total > 0 ? entry.count / total * 100 : 0).toFixed(1)}%`,
+                (entry.samples || []).join('\n')
+            ];
+
+            const row = document.createElement('tr');
+            cells.forEach((value, index) => {
+                const cell = document.createElement('td');
+                cell.textContent = value;
+                if (index === cells.length - 1) {
+                    cell.className = 'samples';
+                }
+                row.appendChild(cell);
+            });
+            body.appendChild(row);
+        });
+    }
+
+    updateTransactions(transactions) {
+        const panel = document.getElementById('transactions');
+        const body = document.getElementById('transactionsBody');
+
+        if (!transactions || transactions.length === 0) {
+            panel.style.display = 'none';
+            body.innerHTML = '';
+            return;
+        }
+
+        panel.style.display = 'block';
+        body.innerHTML = '';
+
+        const addRow = (name, stats, className) => {
+            const current = (stats && stats.current_stat) || {};
+            const cells = [
+                name,
+                ((stats && stats.total_requests) || 0).toLocaleString(),
+                Math.round((current.TpsSuccess || 0) + (current.TpsFailure || 0)),
+                this.formatResponseTime(stats && stats.cumulative_avg_rt),
+                this.formatResponseTime(current.ResponseTime99),
+                `${((stats && stats.cumulative_error_rate) || 0).toFixed(1)}%`
+            ];
+
+            const row = document.createElement('tr');
+            row.className = className;
+            cells.forEach(value => {
+                const cell = document.createElement('td');
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            body.appendChild(row);
+        };
+
+        transactions.forEach(transaction => {
+            addRow(transaction.name, transaction.total, 'transaction-row');
+            (transaction.steps || []).forEach(step => addRow(step.name, step, 'step-row'));
+        });
+    }
+
+    // updateTransactionCharts renders a stacked step latency chart per transaction
+    updateTransactionCharts(transactionCharts) {
+        transactionCharts.forEach(transaction => {
+            const tier = (transaction.tiers || []).find(tier => tier.time_ms && tier.time_ms.length > 0);
+            if (!tier || !transaction.steps || transaction.steps.length === 0) return;
+
+            const startTime = tier.time_ms[0];
+            const spanMs = tier.time_ms[tier.time_ms.length - 1] - startTime;
+            const labels = tier.time_ms.map(timeMs =>
+                this.formatTimeLabel(timeMs - startTime, tier.interval_ms, spanMs));
+
+            const datasets = transaction.steps.map((step, index) => {
+                const color = this.palette[index % this.palette.length];
+                return {
+                    label: step,
+                    data: tier.response_times[index].map(ms => this.toDisplayUnit(ms)),
+                    borderColor: color,
+                    backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.5)'),
+                    fill: true
+                };
+            });
+
+            this.updateChartData(this.transactionChart(transaction.name), labels, datasets,
+                { timeMs: tier.time_ms, intervalMs: tier.interval_ms });
+        });
+    }
+
+    // transactionChart returns the step chart of a transaction, creating its panel on first use
+    transactionChart(name) {
+        if (this.transactionCharts[name]) {
+            return this.transactionCharts[name];
+        }
+
+        const panel = document.createElement('div');
+        panel.className = 'chart-panel';
+        const title = document.createElement('div');
+        title.className = 'chart-title';
+        title.innerHTML = `<span></span> step latency (<span class="rt-unit">${this.displayUnit}</span>)`;
+        title.firstChild.textContent = name;
+        const canvas = document.createElement('canvas');
+        panel.appendChild(title);
+        panel.appendChild(canvas);
+        document.getElementById('transactionCharts').appendChild(panel);
+
+        this.transactionCharts[name] = new Chart(canvas.getContext('2d'), {
+            ...this.chartConfig,
+            options: {
+                ...this.chartConfig.options,
+                scales: {
+                    xAxes: [{ stacked: true }],
+                    yAxes: [{ stacked: true, ticks: { beginAtZero: true } }]
+                }
+            },
+            data: { labels: [], datasets: [] }
+        });
+        return this.transactionCharts[name];
+    }
+
+    colorForErrorClass(errorClass) {
+        if (!this.errorClassColors[errorClass]) {
+            const index = Object.keys(this.errorClassColors).length % this.palette.length;
+            this.errorClassColors[errorClass] = this.palette[index];
+        }
+        return this.errorClassColors[errorClass];
+    }
+
+    // Status codes by frequency, e.g. "200: 950, 503: 50"
+    formatStatusCodes(responses) {
+        if (!responses || !responses.status_codes) {
+            return '-';
+        }
+        return Object.entries(responses.status_codes)
+            .sort((a, b) => b[1] - a[1])
+            .map(([code, count]) => `${code}: ${count.toLocaleString()}`)
+            .join(', ');
+    }
+
+    formatBytes(bytes) {
+        if (bytes >= 1024 * 1024) {
+            return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+        }
+        if (bytes >= 1024) {
+            return `${(bytes / 1024).toFixed(1)} KB`;
+        }
+        return `${Math.round(bytes)} B`;
+    }
+
+    updateLabelBreakdown(labels) {
+        const panel = document.getElementById('labelBreakdown');
+        const body = document.getElementById('labelBreakdownBody');
+
+        if (!labels || labels.length === 0) {
+            panel.style.display = 'none';
+            body.innerHTML = '';
+            return;
+        }
+
+        panel.style.display = 'block';
+        body.innerHTML = '';
+
+        labels.forEach(label => {
+            const current = label.current_stat || {};
+            const cells = [
+                label.key,
+                (label.total_requests || 0).toLocaleString(),
+                Math.round((current.TpsSuccess || 0) + (current.TpsFailure || 0)),
+                this.formatResponseTime(label.cumulative_avg_rt),
+                this.formatResponseTime(current.ResponseTime99),
+                `${(label.cumulative_error_rate || 0).toFixed(1)}%`,
+                this.formatStatusCodes(label.responses),
+                label.responses ? this.formatBytes(label.responses.mean_bytes) : '-'
+            ];
+
+            const row = document.createElement('tr');
+            cells.forEach(value => {
+                const cell = document.createElement('td');
+                cell.textContent = value;
+                row.appendChild(cell);
+            });
+            body.appendChild(row);
+        });
+    }
+
+    setDisplayUnit(unit) {
+        if (!unit || unit === this.displayUnit) return;
+
+        this.displayUnit = unit;
+        document.querySelectorAll('.rt-unit').forEach(element => {
+            element.textContent = unit;
+        });
+    }
+
+    // toDisplayUnit converts a response time in milliseconds to the display unit
+    toDisplayUnit(ms) {
+        switch (this.displayUnit) {
+            case 'us':
+                return (ms || 0) * 1000;
+            case 's':
+                return (ms || 0) / 1000;
+            default:
+                return ms || 0;
+        }
+    }
+
+    // formatResponseTime formats a response time in milliseconds for the stat cards
+    formatResponseTime(ms) {
+        const value = this.toDisplayUnit(ms);
+        if (value >= 100) return Math.round(value).toString();
+        if (value >= 1) return value.toFixed(2);
+        return value.toFixed(3);
     }
 
     updateRealTimeStatsWithSession(latestStat, sessionStats) {
@@ -115,11 +407,70 @@ class PerfTestDashboard {
 
         document.getElementById('totalRequests').textContent = totalRequests.toLocaleString();
         document.getElementById('currentTPS').textContent = Math.round(currentTPS);
-        document.getElementById('avgResponseTime').textContent = Math.round(avgResponseTime);
+        document.getElementById('avgResponseTime').textContent = this.formatResponseTime(avgResponseTime);
         document.getElementById('errorRate').textContent = `${(latestStat.ErrorRate || 0).toFixed(1)}%`;
+        document.getElementById('inFlight').textContent = (sessionStats.in_flight || 0).toLocaleString();
+        document.getElementById('peakInFlight').textContent = (sessionStats.peak_in_flight || 0).toLocaleString();
+        this.updateSessionLatency(this.showCorrected && sessionStats.corrected_success_latency
+            ? sessionStats.corrected_success_latency : sessionStats.success_latency);
 
         // Log for debugging
-        console.log(`Success RT: ${Math.round(latestStat.ResponseTime || 0)}ms, Error RT: ${Math.round(latestStat.FailureResponseTime || 0)}ms, Overall Avg: ${Math.round(avgResponseTime)}ms`);
+        const unit = this.displayUnit;
+        console.log(`Success RT: ${this.formatResponseTime(latestStat.ResponseTime)}${unit}, Error RT: ${this.formatResponseTime(latestStat.FailureResponseTime)}${unit}, Overall Avg: ${this.formatResponseTime(avgResponseTime)}${unit}`);
+    }
+
+    initializeCorrectedToggle() {
+        document.getElementById('correctedCheckbox').addEventListener('change', event => {
+            this.showCorrected = event.target.checked;
+            if (this.lastMessage) {
+                this.handleOptimizedData(this.lastMessage);
+            }
+        });
+    }
+
+    // updateCorrectedToggle offers the corrected view only when the session reports intended start times
+    updateCorrectedToggle(data, sessionStats) {
+        const available = (sessionStats && sessionStats.corrected_success_latency) ||
+            (data || []).some(stat => stat.Corrected);
+        document.getElementById('correctedToggle').style.display = available ? 'block' : 'none';
+    }
+
+    updateSessionLatency(latency) {
+        // One item per configured percentile, ahead of min, max and std dev
+        const grid = document.getElementById('sessionLatency');
+        grid.querySelectorAll('.session-percentile').forEach(item => item.remove());
+
+        const percentiles = (latency && latency.percentiles) || {};
+        const first = grid.firstElementChild;
+        Object.keys(percentiles)
+            .sort((a, b) => parseFloat(a.slice(1)) - parseFloat(b.slice(1)))
+            .forEach(key => {
+                const item = document.createElement('div');
+                item.className = 'stat-item session-percentile';
+
+                const value = document.createElement('div');
+                value.className = 'stat-value';
+                value.textContent = this.formatResponseTime(percentiles[key]);
+
+                const label = document.createElement('div');
+                label.className = 'stat-label';
+                label.innerHTML = `${key} (<span class="rt-unit">${this.displayUnit}</span>)`;
+
+                item.appendChild(value);
+                item.appendChild(label);
+                grid.insertBefore(item, first);
+            });
+
+        const fields = {
+            sessionMin: 'min',
+            sessionMax: 'max',
+            sessionStdDev: 'stddev'
+        };
+
+        Object.entries(fields).forEach(([elementId, field]) => {
+            document.getElementById(elementId).textContent =
+                latency ? this.formatResponseTime(latency[field]) : '-';
+        });
     }
 
     updateRealTimeStats(latestStat) {
@@ -131,20 +482,34 @@ class PerfTestDashboard {
 
         document.getElementById('totalRequests').textContent = totalRequests.toLocaleString();
         document.getElementById('currentTPS').textContent = Math.round(currentTPS);
-        document.getElementById('avgResponseTime').textContent = Math.round(latestStat.ResponseTime || 0);
+        document.getElementById('avgResponseTime').textContent = this.formatResponseTime(latestStat.ResponseTime);
         document.getElementById('errorRate').textContent = `${(latestStat.ErrorRate || 0).toFixed(1)}%`;
     }
 
     selectBestDataset(chartData) {
-        // Priority: recent -> medium -> longterm
-        if (chartData.recent && chartData.recent.length > 0) {
-            return chartData.recent;
-        } else if (chartData.medium && chartData.medium.length > 0) {
-            return chartData.medium;
-        } else if (chartData.longterm && chartData.longterm.length > 0) {
-            return chartData.longterm;
+        // Priority: finest tier with data first
+        const tier = (chartData.tiers || []).find(tier => tier.stats && tier.stats.length > 0);
+        return tier ? tier.stats : [];
+    }
+
+    // formatTimeLabel formats a time offset for the x axis, with finer units
+    // for short intervals and coarser units for long spans
+    formatTimeLabel(offsetMs, intervalMs, spanMs) {
+        const seconds = offsetMs / 1000;
+        if (intervalMs < 1000) {
+            return `${seconds.toFixed(1)}s`;
         }
-        return [];
+        if (spanMs < 10 * 60 * 1000) {
+            return `${Math.round(seconds)}s`;
+        }
+
+        const totalMinutes = Math.floor(seconds / 60);
+        const hours = Math.floor(totalMinutes / 60);
+        const minutes = totalMinutes % 60;
+        if (hours > 0) {
+            return `${hours}h${String(minutes).padStart(2, '0')}m`;
+        }
+        return `${minutes}m${String(Math.round(seconds % 60)).padStart(2, '0')}s`;
     }
 
     updateCharts(data) {
@@ -152,21 +517,29 @@ class PerfTestDashboard {
         const totalTPSData = [];
         const successTPSData = [];
         const errorTPSData = [];
-        const successResponseTimeData = [];
-        const successResponseTime90Data = [];
-        const successResponseTime95Data = [];
-        const successResponseTime99Data = [];
-        const errorResponseTimeData = [];
-        const errorResponseTime90Data = [];
-        const errorResponseTime95Data = [];
-        const errorResponseTime99Data = [];
+        const successResponseTimeData = this.newResponseTimeSeries();
+        const errorResponseTimeData = this.newResponseTimeSeries();
         const errorRateData = [];
+        const errorClassData = {};
+        const inFlightData = [];
+        const peakInFlightData = [];
+        const avgConcurrencyData = [];
+        const queueTimeData = [];
+        const queueTime99Data = [];
+
+        const startTime = data[0]?.TimeMs || 0;
+        const lastStat = data[data.length - 1] || {};
+        const spanMs = (lastStat.TimeMs || 0) - startTime;
 
-        const startTime = data[0]?.Time || 0;
+        // Point times, shared by every chart below to place annotations
+        this.timeline = {
+            timeMs: data.map(stat => stat.TimeMs),
+            intervalMs: data[0]?.IntervalMs || 1000
+        };
 
         data.forEach((stat, index) => {
-            const relativeTime = stat.Time - startTime;
-            labels.push(relativeTime);
+            const intervalMs = stat.IntervalMs || 1000;
+            labels.push(this.formatTimeLabel(stat.TimeMs - startTime, intervalMs, spanMs));
 
             // TPS data
             const totalTPS = (stat.TpsSuccess || 0) + (stat.TpsFailure || 0);
@@ -174,20 +547,39 @@ class PerfTestDashboard {
             successTPSData.push(stat.TpsSuccess || 0);
             errorTPSData.push(stat.TpsFailure || 0);
 
+            // Concurrency data
+            inFlightData.push(stat.InFlight || 0);
+            peakInFlightData.push(stat.PeakInFlight || 0);
+            avgConcurrencyData.push(stat.AvgConcurrency || 0);
+
+            // Queue time data, a gap when nothing was queued
+            queueTimeData.push(stat.QueueTime ? this.toDisplayUnit(stat.QueueTime) : null);
+            queueTime99Data.push(stat.QueueTime99 ? this.toDisplayUnit(stat.QueueTime99) : null);
+
+            // Response times, corrected for coordinated omission if selected
+            const latency = this.showCorrected && stat.Corrected ? stat.Corrected : stat;
+
             // Success Response Time data
-            successResponseTimeData.push(stat.ResponseTime || 0);
-            successResponseTime90Data.push(stat.ResponseTime90 || 0);
-            successResponseTime95Data.push(stat.ResponseTime95 || 0);
-            successResponseTime99Data.push(stat.ResponseTime99 || 0);
+            this.pushResponseTimes(successResponseTimeData, stat.SuccessCount, latency.ResponseTime,
+                latency.Percentiles, latency.ResponseTimeMax);
 
             // Error Response Time data
-            errorResponseTimeData.push(stat.FailureResponseTime || 0);
-            errorResponseTime90Data.push(stat.FailureResponseTime90 || 0);
-            errorResponseTime95Data.push(stat.FailureResponseTime95 || 0);
-            errorResponseTime99Data.push(stat.FailureResponseTime99 || 0);
+            this.pushResponseTimes(errorResponseTimeData, stat.FailureCount, latency.FailureResponseTime,
+                latency.FailurePercentiles, latency.FailureResponseTimeMax);
 
             // Error Rate data
             errorRateData.push(stat.ErrorRate || 0);
+
+            // Error class data, padded with zeros for seconds without that class
+            Object.keys(stat.ErrorClasses || {}).forEach(errorClass => {
+                if (!errorClassData[errorClass]) {
+                    errorClassData[errorClass] = new Array(index).fill(0);
+                }
+            });
+            Object.keys(errorClassData).forEach(errorClass => {
+                const count = (stat.ErrorClasses || {})[errorClass] || 0;
+                errorClassData[errorClass].push(count * 1000 / intervalMs);
+            });
         });
 
         // Update Total TPS chart
@@ -199,6 +591,28 @@ class PerfTestDashboard {
             fill: true
         }]);
 
+        // Update Concurrency chart
+        this.updateChartData(this.charts.concurrency, labels, [{
+            label: 'Peak In Flight',
+            data: peakInFlightData,
+            borderColor: 'rgb(255, 159, 64)',
+            backgroundColor: 'rgba(255, 159, 64, 0.1)',
+            fill: false
+        }, {
+            label: 'In Flight',
+            data: inFlightData,
+            borderColor: 'rgb(123, 104, 238)',
+            backgroundColor: 'rgba(123, 104, 238, 0.1)',
+            fill: true
+        }, {
+            label: "Average (Little's law)",
+            data: avgConcurrencyData,
+            borderColor: 'rgb(75, 192, 192)',
+            backgroundColor: 'rgba(75, 192, 192, 0.1)',
+            borderDash: [5, 5],
+            fill: false
+        }]);
+
         // Update Success TPS chart
         this.updateChartData(this.charts.successTPS, labels, [{
             label: 'Success TPS',
@@ -217,69 +631,42 @@ class PerfTestDashboard {
             fill: true
         }]);
 
+        // Update Errors by Class chart
+        this.updateChartData(this.charts.errorClass, labels,
+            Object.keys(errorClassData).sort().map(errorClass => {
+                const color = this.colorForErrorClass(errorClass);
+                return {
+                    label: errorClass,
+                    data: errorClassData[errorClass],
+                    borderColor: color,
+                    backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.5)'),
+                    fill: true
+                };
+            })
+        );
+
         // Update Success Response Time chart
-        this.updateChartData(this.charts.successResponseTime, labels, [
-            {
-                label: 'Average',
-                data: successResponseTimeData,
-                borderColor: 'rgb(54, 162, 235)',
-                backgroundColor: 'rgba(54, 162, 235, 0.1)',
-                fill: false
-            },
-            {
-                label: '90th Percentile',
-                data: successResponseTime90Data,
-                borderColor: 'rgb(255, 206, 86)',
-                backgroundColor: 'rgba(255, 206, 86, 0.1)',
-                fill: false
-            },
-            {
-                label: '95th Percentile',
-                data: successResponseTime95Data,
-                borderColor: 'rgb(255, 159, 64)',
-                backgroundColor: 'rgba(255, 159, 64, 0.1)',
-                fill: false
-            },
-            {
-                label: '99th Percentile',
-                data: successResponseTime99Data,
-                borderColor: 'rgb(255, 99, 132)',
-                backgroundColor: 'rgba(255, 99, 132, 0.1)',
-                fill: false
-            }
-        ]);
+        this.updateChartData(this.charts.successResponseTime, labels,
+            this.responseTimeDatasets(successResponseTimeData, 'rgb(54, 162, 235)'));
 
         // Update Error Response Time chart
-        this.updateChartData(this.charts.errorResponseTime, labels, [
-            {
-                label: 'Average',
-                data: errorResponseTimeData,
-                borderColor: 'rgb(220, 53, 69)',
-                backgroundColor: 'rgba(220, 53, 69, 0.1)',
-                fill: false
-            },
-            {
-                label: '90th Percentile',
-                data: errorResponseTime90Data,
-                borderColor: 'rgb(255, 193, 7)',
-                backgroundColor: 'rgba(255, 193, 7, 0.1)',
-                fill: false
-            },
-            {
-                label: '95th Percentile',
-                data: errorResponseTime95Data,
-                borderColor: 'rgb(253, 126, 20)',
-                backgroundColor: 'rgba(253, 126, 20, 0.1)',
-                fill: false
-            },
-            {
-                label: '99th Percentile',
-                data: errorResponseTime99Data,
-                borderColor: 'rgb(214, 51, 132)',
-                backgroundColor: 'rgba(214, 51, 132, 0.1)',
-                fill: false
-            }
-        ]);
+        this.updateChartData(this.charts.errorResponseTime, labels,
+            this.responseTimeDatasets(errorResponseTimeData, 'rgb(220, 53, 69)'));
+
+        // Update Queue Time chart
+        this.updateChartData(this.charts.queueTime, labels, [{
+            label: 'Average',
+            data: queueTimeData,
+            borderColor: 'rgb(54, 162, 235)',
+            backgroundColor: 'rgba(54, 162, 235, 0.1)',
+            fill: false
+        }, {
+            label: 'p99',
+            data: queueTime99Data,
+            borderColor: 'rgb(255, 99, 132)',
+            backgroundColor: 'rgba(255, 99, 132, 0.1)',
+            fill: false
+        }]);
 
         // Update Error Rate chart
         this.updateChartData(this.charts.errorRate, labels, [{
@@ -289,14 +676,314 @@ class PerfTestDashboard {
             backgroundColor: 'rgba(255, 99, 132, 0.1)',
             fill: true
         }]);
+
+        this.updateMetricCharts(labels, data);
+        this.updateRuntimeCharts(labels, data);
+        this.updateArrivalRateChart(labels, data);
+        this.updatePhaseCharts(labels, data);
+    }
+
+    // updatePhaseCharts renders the HTTP phase breakdown, shown only for
+    // sessions with traced HTTP requests
+    updatePhaseCharts(labels, data) {
+        const available = data.some(stat => stat.Phases);
+        document.getElementById('httpPhasesPanel').style.display = available ? 'block' : 'none';
+        document.getElementById('connReusePanel').style.display = available ? 'block' : 'none';
+        if (!available) return;
+
+        const phases = [
+            ['DNS', 'dns', 'rgb(153, 102, 255)'],
+            ['Connect', 'connect', 'rgb(255, 159, 64)'],
+            ['TLS', 'tls', 'rgb(255, 206, 86)'],
+            ['Wait (TTFB)', 'wait', 'rgb(54, 162, 235)'],
+            ['Transfer', 'transfer', 'rgb(75, 192, 192)']
+        ];
+        this.updateChartData(this.charts.httpPhases, labels, phases.map(([label, key, color]) => ({
+            label: label,
+            data: data.map(stat => stat.Phases ? this.toDisplayUnit(stat.Phases[key] || 0) : null),
+            borderColor: color,
+            backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.5)'),
+            fill: true
+        })));
+
+        this.updateChartData(this.charts.connReuse, labels, [{
+            label: 'Requests on reused connections (%)',
+            data: data.map(stat => stat.Phases ? stat.Phases.conn_reuse || 0 : null),
+            borderColor: 'rgb(32, 201, 151)',
+            backgroundColor: 'rgba(32, 201, 151, 0.1)',
+            fill: true
+        }]);
+    }
+
+    // updateArrivalRateChart compares the open-model executor's target rate
+    // with the rate it achieved, shown only for sessions that use it
+    updateArrivalRateChart(labels, data) {
+        const panel = document.getElementById('arrivalRatePanel');
+        if (!data.some(stat => stat.ScheduledIterations || stat.TargetRate)) {
+            panel.style.display = 'none';
+            return;
+        }
+        panel.style.display = 'block';
+
+        this.updateChartData(this.charts.arrivalRate, labels, [{
+            label: 'Target',
+            data: data.map(stat => stat.TargetRate || 0),
+            borderColor: 'rgb(201, 203, 207)',
+            backgroundColor: 'rgba(201, 203, 207, 0.1)',
+            borderDash: [5, 5],
+            fill: false
+        }, {
+            label: 'Achieved',
+            data: data.map(stat => stat.AchievedRate || 0),
+            borderColor: 'rgb(75, 192, 192)',
+            backgroundColor: 'rgba(75, 192, 192, 0.1)',
+            fill: true
+        }, {
+            label: 'Missed',
+            data: data.map(stat => (stat.MissedIterations || 0) * 1000 / (stat.IntervalMs || 1000)),
+            borderColor: 'rgb(255, 99, 132)',
+            backgroundColor: 'rgba(255, 99, 132, 0.1)',
+            fill: true
+        }]);
+    }
+
+    // updateRuntimeCharts renders the load generator health section, shown
+    // only for sessions that sample runtime metrics
+    updateRuntimeCharts(labels, data) {
+        const section = document.getElementById('runtimeHealth');
+        if (!data.some(stat => stat.Runtime)) {
+            section.style.display = 'none';
+            return;
+        }
+        section.style.display = 'block';
+
+        const field = (key, scale = 1) =>
+            data.map(stat => stat.Runtime ? (stat.Runtime[key] || 0) / scale : null);
+        const dataset = (label, values, color, fill) => ({
+            label: label,
+            data: values,
+            borderColor: color,
+            backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+            fill: fill
+        });
+        const megabyte = 1024 * 1024;
+
+        this.updateChartData(this.charts.runtimeCPU, labels, [
+            dataset('CPU', field('cpu_percent'), 'rgb(255, 159, 64)', true)
+        ]);
+        this.updateChartData(this.charts.runtimeMemory, labels, [
+            dataset('RSS', field('rss', megabyte), 'rgb(153, 102, 255)', false),
+            dataset('Heap in use', field('heap_in_use', megabyte), 'rgb(54, 162, 235)', true)
+        ]);
+        this.updateChartData(this.charts.runtimeGoroutines, labels, [
+            dataset('Goroutines', field('goroutines'), 'rgb(75, 192, 192)', true)
+        ]);
+        this.updateChartData(this.charts.runtimeGC, labels, [
+            dataset('p50', field('gc_pause_p50'), 'rgb(75, 192, 192)', false),
+            dataset('p99', field('gc_pause_p99'), 'rgb(255, 206, 86)', false),
+            dataset('Max', field('gc_pause_max'), 'rgb(255, 99, 132)', false)
+        ]);
+    }
+
+    // updateMetricCharts renders one auto-generated chart per custom metric
+    updateMetricCharts(labels, data) {
+        const metrics = {};
+        data.forEach((stat, index) => {
+            Object.entries(stat.Metrics || {}).forEach(([name, metric]) => {
+                if (!metrics[name]) {
+                    metrics[name] = { kind: metric.kind, points: new Array(index).fill(null) };
+                }
+            });
+            Object.entries(metrics).forEach(([name, metric]) => {
+                metric.points.push((stat.Metrics || {})[name] || null);
+            });
+        });
+
+        Object.keys(metrics).sort().forEach(name => {
+            const metric = metrics[name];
+            this.updateChartData(this.metricChart(name, metric.kind), labels,
+                this.metricDatasets(metric.kind, metric.points));
+        });
+    }
+
+    // metricChart returns the chart of a custom metric, creating its panel on first use
+    metricChart(name, kind) {
+        if (this.metricCharts[name]) {
+            return this.metricCharts[name];
+        }
+
+        const titles = { counter: 'per second', gauge: 'gauge', histogram: 'distribution' };
+        const panel = document.createElement('div');
+        panel.className = 'chart-panel';
+        const title = document.createElement('div');
+        title.className = 'chart-title';
+        title.textContent = `${name} (${titles[kind] || kind})`;
+        const canvas = document.createElement('canvas');
+        panel.appendChild(title);
+        panel.appendChild(canvas);
+        document.getElementById('metricCharts').appendChild(panel);
+
+        this.metricCharts[name] = new Chart(canvas.getContext('2d'),
+            { ...this.chartConfig, data: { labels: [], datasets: [] } });
+        return this.metricCharts[name];
+    }
+
+    // metricDatasets builds the datasets of a custom metric chart
+    metricDatasets(kind, points) {
+        const dataset = (label, data, color, fill) => ({
+            label: label,
+            data: data,
+            borderColor: color,
+            backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+            fill: fill
+        });
+
+        switch (kind) {
+            case 'counter':
+                return [dataset('Per second', points.map(point => point ? point.rate || 0 : 0),
+                    'rgb(123, 104, 238)', true)];
+            case 'gauge':
+                return [dataset('Value', points.map(point => point ? point.value : null),
+                    'rgb(75, 192, 192)', true)];
+            default: {
+                const field = key => points.map(point => point && point.count ? point[key] : null);
+                return [
+                    dataset('Average', field('value'), 'rgb(54, 162, 235)', false),
+                    dataset('p50', field('p50'), 'rgb(75, 192, 192)', false),
+                    dataset('p90', field('p90'), 'rgb(255, 206, 86)', false),
+                    dataset('p99', field('p99'), 'rgb(255, 99, 132)', false)
+                ];
+            }
+        }
+    }
+
+    percentileKey(percentile) {
+        return `p${percentile}`;
+    }
+
+    percentileLabel(percentile) {
+        return percentile === 50 ? 'Median (p50)' : `p${percentile}`;
     }
 
-    updateChartData(chart, labels, datasets) {
+    newResponseTimeSeries() {
+        const series = { average: [], max: [], percentiles: {} };
+        this.percentiles.forEach(percentile => {
+            series.percentiles[this.percentileKey(percentile)] = [];
+        });
+        return series;
+    }
+
+    // pushResponseTimes adds one point; intervals without requests become gaps
+    // rather than zero latency
+    pushResponseTimes(series, count, average, percentiles, max) {
+        if (!count) {
+            series.average.push(null);
+            series.max.push(null);
+            Object.keys(series.percentiles).forEach(key => series.percentiles[key].push(null));
+            return;
+        }
+
+        series.average.push(this.toDisplayUnit(average));
+        series.max.push(this.toDisplayUnit(max));
+        Object.keys(series.percentiles).forEach(key => {
+            series.percentiles[key].push(this.toDisplayUnit((percentiles || {})[key]));
+        });
+    }
+
+    // responseTimeDatasets builds one dataset per configured percentile plus average and max
+    responseTimeDatasets(series, averageColor) {
+        const percentileColors = [
+            'rgb(75, 192, 192)', 'rgb(255, 206, 86)', 'rgb(255, 159, 64)',
+            'rgb(255, 99, 132)', 'rgb(153, 102, 255)', 'rgb(214, 51, 132)',
+            'rgb(32, 201, 151)', 'rgb(108, 117, 125)'
+        ];
+
+        const datasets = [{
+            label: 'Average',
+            data: series.average,
+            borderColor: averageColor,
+            backgroundColor: averageColor.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+            fill: false
+        }];
+
+        this.percentiles.forEach((percentile, index) => {
+            const color = percentileColors[index % percentileColors.length];
+            datasets.push({
+                label: this.percentileLabel(percentile),
+                data: series.percentiles[this.percentileKey(percentile)],
+                borderColor: color,
+                backgroundColor: color.replace('rgb', 'rgba').replace(')', ', 0.1)'),
+                fill: false
+            });
+        });
+
+        datasets.push({
+            label: 'Max',
+            data: series.max,
+            borderColor: 'rgb(52, 58, 64)',
+            backgroundColor: 'rgba(52, 58, 64, 0.1)',
+            borderDash: [4, 4],
+            fill: false,
+            hidden: true
+        });
+
+        return datasets;
+    }
+
+    updateChartData(chart, labels, datasets, timeline = this.timeline) {
         chart.data.labels = labels;
         chart.data.datasets = datasets;
+        chart.data.timeline = timeline;
         chart.update('none'); // Disable animation for better performance
     }
 
+    // annotationIndex returns the index of the point an annotation falls into,
+    // or -1 if it is outside the timeline
+    annotationIndex(timeline, annotation) {
+        if (!timeline || timeline.timeMs.length === 0 || annotation.time_ms < timeline.timeMs[0]) {
+            return -1;
+        }
+        return timeline.timeMs.findIndex(timeMs => annotation.time_ms < timeMs + timeline.intervalMs);
+    }
+
+    // stageAt returns the last annotation, e.g. load profile stage, that began
+    // by the end of a point
+    stageAt(timeline, index) {
+        if (!timeline || index === undefined || index >= timeline.timeMs.length) return '';
+
+        const end = timeline.timeMs[index] + timeline.intervalMs;
+        const begun = this.annotations.filter(annotation => annotation.time_ms < end);
+        return begun.length > 0 ? begun[begun.length - 1].text : '';
+    }
+
+    // drawAnnotations draws a labeled vertical line at every annotation
+    drawAnnotations(chart) {
+        const timeline = chart.data.timeline;
+        const xScale = Object.values(chart.scales).find(scale => scale.isHorizontal());
+        if (!timeline || !xScale || this.annotations.length === 0) return;
+
+        const { top, bottom } = chart.chartArea;
+        const ctx = chart.ctx;
+        ctx.save();
+        ctx.strokeStyle = 'rgba(108, 117, 125, 0.7)';
+        ctx.fillStyle = '#6c757d';
+        ctx.font = '10px sans-serif';
+        ctx.setLineDash([4, 4]);
+
+        this.annotations.forEach(annotation => {
+            const index = this.annotationIndex(timeline, annotation);
+            if (index < 0) return;
+
+            const x = xScale.getPixelForValue(null, index);
+            ctx.beginPath();
+            ctx.moveTo(x, top);
+            ctx.lineTo(x, bottom);
+            ctx.stroke();
+            ctx.fillText(annotation.text, x + 3, top + 10);
+        });
+        ctx.restore();
+    }
+
     updateSessionInfo(sessionData) {
         document.getElementById('sessionName').textContent = sessionData.session_name || 'No active session';
 
@@ -314,10 +1001,22 @@ class PerfTestDashboard {
     }
 
     initializeCharts() {
+        // Marks annotations such as load profile stages on every chart
+        const annotationPlugin = {
+            afterDatasetsDraw: chart => this.drawAnnotations(chart)
+        };
+
         const chartConfig = {
             type: 'line',
+            plugins: [annotationPlugin],
             options: {
                 responsive: true,
+                tooltips: {
+                    callbacks: {
+                        // Name the stage each point came from
+                        footer: (items, data) => this.stageAt(data.timeline, items[0]?.index)
+                    }
+                },
                 interaction: {
                     intersect: false,
                     mode: 'index'
@@ -350,12 +1049,25 @@ class PerfTestDashboard {
             }
         };
 
-        // Initialize all 6 charts
+        // Kept for the charts of custom metrics, which are created on demand
+        this.chartConfig = chartConfig;
+
+        // Initialize all charts
         this.charts.totalTPS = new Chart(
             document.getElementById('totalTPSChart').getContext('2d'),
             { ...chartConfig, data: { labels: [], datasets: [] } }
         );
 
+        this.charts.concurrency = new Chart(
+            document.getElementById('concurrencyChart').getContext('2d'),
+            { ...chartConfig, data: { labels: [], datasets: [] } }
+        );
+
+        this.charts.arrivalRate = new Chart(
+            document.getElementById('arrivalRateChart').getContext('2d'),
+            { ...chartConfig, data: { labels: [], datasets: [] } }
+        );
+
         this.charts.successTPS = new Chart(
             document.getElementById('successTPSChart').getContext('2d'),
             { ...chartConfig, data: { labels: [], datasets: [] } }
@@ -366,6 +1078,21 @@ class PerfTestDashboard {
             { ...chartConfig, data: { labels: [], datasets: [] } }
         );
 
+        this.charts.errorClass = new Chart(
+            document.getElementById('errorClassChart').getContext('2d'),
+            {
+                ...chartConfig,
+                options: {
+                    ...chartConfig.options,
+                    scales: {
+                        xAxes: [{ stacked: true }],
+                        yAxes: [{ stacked: true, ticks: { beginAtZero: true } }]
+                    }
+                },
+                data: { labels: [], datasets: [] }
+            }
+        );
+
         this.charts.successResponseTime = new Chart(
             document.getElementById('successResponseTimeChart').getContext('2d'),
             { ...chartConfig, data: { labels: [], datasets: [] } }
@@ -376,10 +1103,43 @@ class PerfTestDashboard {
             { ...chartConfig, data: { labels: [], datasets: [] } }
         );
 
+        this.charts.queueTime = new Chart(
+            document.getElementById('queueTimeChart').getContext('2d'),
+            { ...chartConfig, data: { labels: [], datasets: [] } }
+        );
+
+        this.charts.httpPhases = new Chart(
+            document.getElementById('httpPhasesChart').getContext('2d'),
+            {
+                ...chartConfig,
+                options: {
+                    ...chartConfig.options,
+                    scales: {
+                        xAxes: [{ stacked: true }],
+                        yAxes: [{ stacked: true, ticks: { beginAtZero: true } }]
+                    }
+                },
+                data: { labels: [], datasets: [] }
+            }
+        );
+
+        this.charts.connReuse = new Chart(
+            document.getElementById('connReuseChart').getContext('2d'),
+            { ...chartConfig, data: { labels: [], datasets: [] } }
+        );
+
         this.charts.errorRate = new Chart(
             document.getElementById('errorRateChart').getContext('2d'),
             { ...chartConfig, data: { labels: [], datasets: [] } }
         );
+
+        // Load generator health
+        ['runtimeCPU', 'runtimeMemory', 'runtimeGoroutines', 'runtimeGC'].forEach(name => {
+            this.charts[name] = new Chart(
+                document.getElementById(`${name}Chart`).getContext('2d'),
+                { ...chartConfig, data: { labels: [], datasets: [] } }
+            );
+        });
     }
 
     resetCharts() {
@@ -389,11 +1149,30 @@ class PerfTestDashboard {
             chart.update();
         });
 
+        this.lastMessage = null;
+        this.annotations = [];
+        this.timeline = null;
+
+        // Custom metrics differ between sessions, so their charts are recreated
+        Object.values(this.metricCharts).forEach(chart => chart.destroy());
+        this.metricCharts = {};
+        document.getElementById('metricCharts').innerHTML = '';
+        Object.values(this.transactionCharts).forEach(chart => chart.destroy());
+        this.transactionCharts = {};
+        document.getElementById('transactionCharts').innerHTML = '';
+        this.updateTransactions([]);
+
         // Reset stats display
         document.getElementById('totalRequests').textContent = '0';
         document.getElementById('currentTPS').textContent = '0';
         document.getElementById('avgResponseTime').textContent = '0';
         document.getElementById('errorRate').textContent = '0%';
+        document.getElementById('inFlight').textContent = '0';
+        document.getElementById('peakInFlight').textContent = '0';
+        this.updateSessionLatency(null);
+        this.updateLabelBreakdown([]);
+        this.updateErrorClasses([]);
+        this.updateDropWarning(null);
     }
 
     startDurationTimer() {
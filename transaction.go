@@ -0,0 +1,371 @@
+package ptest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// TransactionLabel is the label key holding the transaction name
+	TransactionLabel = "transaction"
+	// StepLabel is the label key holding the step name of a transaction step
+	StepLabel = "step"
+)
+
+// Transaction reports a multi-step user journey: every step is reported
+// with its own latency, and the transaction as a whole from its start to
+// its end. The overall statistics count the steps only; the transaction as a
+// whole is reported in its own label set alone. Use a transaction from one
+// goroutine.
+type Transaction struct {
+	session *TestSession
+	name    string
+	start   time.Time
+	labels  map[string]string
+
+	failed     bool
+	errorClass string
+	errorMsg   string
+	ended      bool
+}
+
+// TransactionStep is one timed step of a transaction
+type TransactionStep struct {
+	tx     *Transaction
+	start  time.Time
+	labels map[string]string
+	ended  bool
+}
+
+// Transaction starts a transaction of the session that begins now
+func (ts *TestSession) Transaction(name string) *Transaction {
+	ts.registerStep(name, "")
+
+	return &Transaction{
+		session: ts,
+		name:    name,
+		start:   time.Now(),
+		labels:  map[string]string{TransactionLabel: name},
+	}
+}
+
+// Transaction starts a transaction of the current session that begins now.
+// Without a session the transaction reports nothing.
+func (tr *TestRunner) Transaction(name string) *Transaction {
+	tr.mutex.RLock()
+	session := tr.currentSession
+	tr.mutex.RUnlock()
+
+	if session == nil {
+		return &Transaction{name: name, start: time.Now()}
+	}
+	return session.Transaction(name)
+}
+
+// Step starts a step of the transaction that begins now
+func (tx *Transaction) Step(name string) *TransactionStep {
+	if tx.session != nil {
+		tx.session.registerStep(tx.name, name)
+	}
+
+	return &TransactionStep{
+		tx:     tx,
+		start:  time.Now(),
+		labels: map[string]string{TransactionLabel: tx.name, StepLabel: name},
+	}
+}
+
+// End reports the step as finished now. A failed step fails the transaction.
+func (s *TransactionStep) End(success bool) {
+	s.end(success, nil)
+}
+
+// EndError reports the step as finished now; a nil error is a success and
+// any other error fails the transaction
+func (s *TransactionStep) EndError(err error) {
+	s.end(err == nil, err)
+}
+
+// end reports the step once
+func (s *TransactionStep) end(success bool, err error) {
+	if s.ended {
+		return
+	}
+	s.ended = true
+
+	trip := Trip{
+		StartTime: s.start,
+		EndTime:   time.Now(),
+		Success:   success,
+		Labels:    s.labels,
+	}
+	s.tx.report(&trip, err)
+
+	if !success && !s.tx.failed {
+		s.tx.failed = true
+		s.tx.errorClass = trip.ErrorClass
+		s.tx.errorMsg = trip.ErrorMessage
+	}
+}
+
+// End reports the transaction as finished now. It succeeds only if success
+// is true and none of its steps failed.
+func (tx *Transaction) End(success bool) {
+	tx.end(success, nil)
+}
+
+// EndError reports the transaction as finished now; it succeeds only if err
+// is nil and none of its steps failed
+func (tx *Transaction) EndError(err error) {
+	tx.end(err == nil, err)
+}
+
+// end reports the transaction once, failed with the first failed step's error
+func (tx *Transaction) end(success bool, err error) {
+	if tx.ended {
+		return
+	}
+	tx.ended = true
+
+	trip := Trip{
+		StartTime:   tx.start,
+		EndTime:     time.Now(),
+		Success:     success && !tx.failed,
+		Labels:      tx.labels,
+		labeledOnly: true,
+	}
+	if tx.failed && err == nil {
+		trip.ErrorClass = tx.errorClass
+		trip.ErrorMessage = tx.errorMsg
+	}
+	tx.report(&trip, err)
+}
+
+// report sends a trip of the transaction to its session
+func (tx *Transaction) report(trip *Trip, err error) {
+	if tx.session == nil || !tx.session.running() {
+		return
+	}
+
+	tx.session.dataCollector.setError(trip, err)
+	tx.session.dataCollector.ReportTrip(trip)
+}
+
+// registerStep remembers the transactions and the order in which their
+// steps first ran; an empty step registers only the transaction
+func (ts *TestSession) registerStep(transaction, step string) {
+	ts.transactionMutex.RLock()
+	known := ts.hasStep(transaction, step)
+	ts.transactionMutex.RUnlock()
+	if known {
+		return
+	}
+
+	ts.transactionMutex.Lock()
+	defer ts.transactionMutex.Unlock()
+
+	if ts.hasStep(transaction, step) {
+		return
+	}
+
+	steps := ts.transactionSteps[transaction]
+	if step != "" {
+		steps = append(steps, step)
+	}
+	ts.transactionSteps[transaction] = steps
+	ts.stepsVersion++
+}
+
+// hasStep reports whether a transaction step is registered. The transaction
+// lock must be held.
+func (ts *TestSession) hasStep(transaction, step string) bool {
+	steps, ok := ts.transactionSteps[transaction]
+	if !ok || step == "" {
+		return ok
+	}
+
+	for _, known := range steps {
+		if known == step {
+			return true
+		}
+	}
+	return false
+}
+
+// stepOrder returns the steps of every transaction in the order they first
+// ran, and a number that changes whenever a step is registered
+func (ts *TestSession) stepOrder() (map[string][]string, uint64) {
+	ts.transactionMutex.RLock()
+	defer ts.transactionMutex.RUnlock()
+
+	result := make(map[string][]string, len(ts.transactionSteps))
+	for transaction, steps := range ts.transactionSteps {
+		result[transaction] = append([]string(nil), steps...)
+	}
+	return result, ts.stepsVersion
+}
+
+// TransactionStats contains cumulative statistics of a transaction and its steps
+type TransactionStats struct {
+	Name string `json:"name"`
+	// Total covers whole transactions from start to end
+	Total *LabelStats `json:"total"`
+	// Steps holds one entry per step, in the order the steps first ran
+	Steps []*TransactionStepStats `json:"steps"`
+}
+
+// TransactionStepStats contains cumulative statistics of one transaction step
+type TransactionStepStats struct {
+	Name string `json:"name"`
+	*LabelStats
+}
+
+// transactionKey returns the label key of a whole transaction
+func transactionKey(transaction string) string {
+	return labelKey(map[string]string{TransactionLabel: transaction})
+}
+
+// stepKey returns the label key of a transaction step
+func stepKey(transaction, step string) string {
+	return labelKey(map[string]string{TransactionLabel: transaction, StepLabel: step})
+}
+
+// GetTransactionStats returns cumulative statistics of every transaction, sorted by name
+func (ts *TestSession) GetTransactionStats() []*TransactionStats {
+	order, _ := ts.stepOrder()
+	if len(order) == 0 {
+		return nil
+	}
+
+	byKey := make(map[string]*LabelStats)
+	for _, stats := range ts.GetLabelStats() {
+		byKey[stats.Key] = stats
+	}
+
+	result := make([]*TransactionStats, 0, len(order))
+	for transaction, steps := range order {
+		stats := &TransactionStats{
+			Name:  transaction,
+			Total: byKey[transactionKey(transaction)],
+		}
+		for _, step := range steps {
+			if stepStats, ok := byKey[stepKey(transaction, step)]; ok {
+				stats.Steps = append(stats.Steps, &TransactionStepStats{Name: step, LabelStats: stepStats})
+			}
+		}
+		result = append(result, stats)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// TransactionChart holds the step latencies of a transaction over time
+type TransactionChart struct {
+	Name string `json:"name"`
+	// Steps lists the steps in the order they first ran
+	Steps []string `json:"steps"`
+	// Tiers holds one series per chart tier, finest resolution first
+	Tiers []*TransactionTier `json:"tiers"`
+}
+
+// TransactionTier holds the step latencies of one chart tier
+type TransactionTier struct {
+	IntervalMs int64   `json:"interval_ms"`
+	TimeMs     []int64 `json:"time_ms"`
+	// ResponseTimes holds each step's average response time of successful
+	// requests in milliseconds per point, in step order; zero where the step
+	// had none
+	ResponseTimes [][]float64 `json:"response_times"`
+}
+
+// transactionChartCache keeps the transaction charts until new data
+// arrives or a step is registered
+type transactionChartCache struct {
+	mutex        sync.Mutex
+	dataVersion  uint64
+	stepsVersion uint64
+	charts       []*TransactionChart
+}
+
+// GetTransactionCharts returns the step latencies of every transaction,
+// sorted by name. The charts are shared between callers until new data
+// arrives, so they must not be modified.
+func (ts *TestSession) GetTransactionCharts() []*TransactionChart {
+	order, stepsVersion := ts.stepOrder()
+	if len(order) == 0 {
+		return nil
+	}
+	dataVersion := ts.chartManager.dataVersion()
+
+	cache := &ts.transactionCharts
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if cache.charts != nil && cache.dataVersion == dataVersion && cache.stepsVersion == stepsVersion {
+		return cache.charts
+	}
+
+	intervals := ts.chartManager.tierIntervals()
+	result := make([]*TransactionChart, 0, len(order))
+	for transaction, steps := range order {
+		chart := &TransactionChart{Name: transaction, Steps: steps}
+
+		stepData := make([]*ChartData, len(steps))
+		for i, step := range steps {
+			stepData[i] = ts.chartManager.labeledData(stepKey(transaction, step))
+		}
+
+		for tierIndex, intervalMs := range intervals {
+			chart.Tiers = append(chart.Tiers, stepTier(intervalMs, tierIndex, stepData))
+		}
+		result = append(result, chart)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+
+	cache.charts = result
+	cache.dataVersion = dataVersion
+	cache.stepsVersion = stepsVersion
+	return result
+}
+
+// stepTier aligns the points of every step in one tier by time; steps
+// without data have nil chart data
+func stepTier(intervalMs int64, tierIndex int, stepData []*ChartData) *TransactionTier {
+	tier := &TransactionTier{
+		IntervalMs:    intervalMs,
+		ResponseTimes: make([][]float64, len(stepData)),
+	}
+
+	seen := make(map[int64]bool)
+	for _, data := range stepData {
+		if data == nil {
+			continue
+		}
+		for _, stat := range data.Tiers[tierIndex].Stats {
+			if !seen[stat.TimeMs] {
+				seen[stat.TimeMs] = true
+				tier.TimeMs = append(tier.TimeMs, stat.TimeMs)
+			}
+		}
+	}
+	sort.Slice(tier.TimeMs, func(i, j int) bool { return tier.TimeMs[i] < tier.TimeMs[j] })
+
+	position := make(map[int64]int, len(tier.TimeMs))
+	for i, timeMs := range tier.TimeMs {
+		position[timeMs] = i
+	}
+
+	for i, data := range stepData {
+		tier.ResponseTimes[i] = make([]float64, len(tier.TimeMs))
+		if data == nil {
+			continue
+		}
+		for _, stat := range data.Tiers[tierIndex].Stats {
+			tier.ResponseTimes[i][position[stat.TimeMs]] = stat.ResponseTime
+		}
+	}
+	return tier
+}
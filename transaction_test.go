@@ -0,0 +1,100 @@
+package ptest
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransactionTotals(t *testing.T) {
+	tests := []struct {
+		name         string
+		transactions int
+		steps        []string
+		failStep     string
+	}{
+		{"one step", 5, []string{"auth"}, ""},
+		{"three steps", 4, []string{"auth", "cart", "pay"}, ""},
+		{"failed step", 3, []string{"auth", "pay"}, "pay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+				for i := 0; i < tt.transactions; i++ {
+					tx := ts.Transaction("checkout")
+					for _, name := range tt.steps {
+						step := tx.Step(name)
+						if name == tt.failStep {
+							step.EndError(errors.New("declined"))
+						} else {
+							step.End(true)
+						}
+					}
+					tx.End(true)
+				}
+			})
+
+			// Only the steps count towards the overall statistics
+			want := int64(tt.transactions * len(tt.steps))
+			if got := ts.cumulativeStats.totalRequests(); got != want {
+				t.Errorf("overall statistics count %d requests, want %d", got, want)
+			}
+
+			stats := ts.GetTransactionStats()
+			if len(stats) != 1 {
+				t.Fatalf("got %d transactions, want 1", len(stats))
+			}
+			total := stats[0].Total
+			if total == nil || total.TotalRequests != int64(tt.transactions) {
+				t.Fatalf("transaction total %+v, want %d requests", total, tt.transactions)
+			}
+			if failed := tt.failStep != ""; failed != (total.CumulativeErrorRate > 0) {
+				t.Errorf("transaction error rate %v with failed step %q", total.CumulativeErrorRate, tt.failStep)
+			}
+			if len(stats[0].Steps) != len(tt.steps) {
+				t.Fatalf("got %d steps, want %d", len(stats[0].Steps), len(tt.steps))
+			}
+			for i, step := range stats[0].Steps {
+				if step.Name != tt.steps[i] || step.TotalRequests != int64(tt.transactions) {
+					t.Errorf("step %d is %q with %d requests, want %q with %d", i, step.Name, step.TotalRequests, tt.steps[i], tt.transactions)
+				}
+			}
+		})
+	}
+}
+
+func TestTransactionChartsCache(t *testing.T) {
+	ts := runSession(t, SessionConfig{}, func(ts *TestSession) {
+		tx := ts.Transaction("checkout")
+		tx.Step("auth").End(true)
+		tx.End(true)
+	})
+
+	first := ts.GetTransactionCharts()
+	if len(first) != 1 || len(first[0].Steps) != 1 {
+		t.Fatalf("got charts %+v, want one transaction with one step", first)
+	}
+
+	tests := []struct {
+		name   string
+		change func()
+		same   bool
+	}{
+		{"unchanged", func() {}, true},
+		{"new step", func() { ts.registerStep("checkout", "pay") }, false},
+		{"new data", func() { ts.chartManager.AddDataPoint(testStat(time.Now(), ts.chartManager.interval, time.Millisecond)) }, false},
+	}
+
+	previous := first
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			charts := ts.GetTransactionCharts()
+			if same := len(charts) > 0 && len(previous) > 0 && charts[0] == previous[0]; same != tt.same {
+				t.Errorf("charts reused = %v, want %v", same, tt.same)
+			}
+			previous = charts
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package ptest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Label keys set by Transport
+const (
+	HostLabel   = "host"
+	MethodLabel = "method"
+	RouteLabel  = "route"
+)
+
+// routeParam replaces path segments that look like identifiers in route templates
+const routeParam = ":id"
+
+// labelsKey is the context key of the label overrides of WithLabels
+type labelsKey struct{}
+
+// WithLabels returns a context whose HTTP requests are reported with the
+// given labels on top of the ones a Transport derives from the request.
+// An empty value removes a label. Overrides of enclosing contexts are kept
+// unless overridden again.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := copyLabels(contextLabels(ctx))
+	if merged == nil {
+		merged = make(map[string]string, len(labels))
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// contextLabels returns the label overrides of a context
+func contextLabels(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// DefaultRoute returns the URL path of a request with segments that look
+// like identifiers, such as numbers, UUIDs and long hex strings, replaced by
+// ":id", so that /users/42/orders and /users/7/orders share a route
+func DefaultRoute(req *http.Request) string {
+	path := req.URL.Path
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = routeParam
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// isIdentifier reports whether a path segment looks like a generated identifier
+func isIdentifier(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	digits, hex := true, true
+	for _, c := range segment {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F', c == '-':
+			digits = false
+		default:
+			return false
+		}
+	}
+	// Hex strings need some length to tell them from words like "add"
+	return digits || (hex && len(segment) >= 16)
+}
+
+// DefaultFailureStatus counts 4xx and 5xx responses as failures
+func DefaultFailureStatus(code int) bool {
+	return code >= 400
+}
+
+// Transport is an http.RoundTripper that times every round trip of the
+// requests it sends and reports it to the current session of a runner,
+// labeled with the host, method and route of the request. A round trip ends
+// when its response body is read to the end or closed.
+type Transport struct {
+	// Base sends the requests; http.DefaultTransport when nil
+	Base http.RoundTripper
+	// Route maps a request to its route template; DefaultRoute when nil
+	Route func(req *http.Request) string
+	// FailureStatus decides which status codes count as failures;
+	// DefaultFailureStatus when nil
+	FailureStatus func(code int) bool
+
+	runner *TestRunner
+}
+
+// Transport wraps a round tripper so that every round trip is reported to
+// the current session. Use it as the Transport of an existing http.Client;
+// set the fields of the returned Transport to customize it.
+func (tr *TestRunner) Transport(base http.RoundTripper) *Transport {
+	return &Transport{Base: base, runner: tr}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tok := t.runner.BeginWithLabels(t.labels(req))
+	tracer := &phaseTracer{}
+	tok.tracer = tracer
+
+	resp, err := t.base().RoundTrip(tracer.trace(req))
+	if err != nil {
+		tok.EndError(err)
+		return resp, err
+	}
+
+	tracer.respond(resp.StatusCode)
+
+	var statusErr error
+	if t.failed(resp.StatusCode) {
+		statusErr = NewStatusError(resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	resp.Body = &reportingBody{ReadCloser: resp.Body, tok: tok, err: statusErr, tracer: tracer}
+	return resp, nil
+}
+
+// labels returns the label set of a request
+func (t *Transport) labels(req *http.Request) map[string]string {
+	route := DefaultRoute
+	if t.Route != nil {
+		route = t.Route
+	}
+
+	labels := map[string]string{
+		HostLabel:   req.URL.Host,
+		MethodLabel: req.Method,
+		RouteLabel:  route(req),
+	}
+	for k, v := range contextLabels(req.Context()) {
+		if v == "" {
+			delete(labels, k)
+		} else {
+			labels[k] = v
+		}
+	}
+	return labels
+}
+
+// base returns the round tripper sending the requests
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// failed reports whether a status code counts as a failure
+func (t *Transport) failed(code int) bool {
+	if t.FailureStatus != nil {
+		return t.FailureStatus(code)
+	}
+	return DefaultFailureStatus(code)
+}
+
+// reportingBody counts the bytes of a response body and ends the token of
+// its round trip once the body is read to the end or closed
+type reportingBody struct {
+	io.ReadCloser
+	tok    *Token
+	err    error
+	tracer *phaseTracer
+	once   sync.Once
+}
+
+// Read implements io.Reader
+func (b *reportingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.tracer.addSize(int64(n))
+	if err == io.EOF {
+		b.end(nil)
+	} else if err != nil {
+		b.end(err)
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *reportingBody) Close() error {
+	b.end(nil)
+	return b.ReadCloser.Close()
+}
+
+// end reports the round trip once, failed with the status error or a read error
+func (b *reportingBody) end(readErr error) {
+	b.once.Do(func() {
+		b.tracer.finish()
+		if b.err != nil {
+			b.tok.EndError(b.err)
+		} else {
+			b.tok.EndError(readErr)
+		}
+	})
+}
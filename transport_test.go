@@ -0,0 +1,269 @@
+package ptest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowRoute is the path of the test server that answers after slowDelay
+const (
+	slowRoute = "/slow"
+	slowDelay = 50 * time.Millisecond
+)
+
+// newTransportServer starts a server that answers with the status code given
+// by the query parameter "status" and a short body, and waits slowDelay
+// before answering requests to slowRoute
+func newTransportServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == slowRoute {
+			time.Sleep(slowDelay)
+		}
+		if status, err := strconv.Atoi(r.URL.Query().Get("status")); err == nil {
+			w.WriteHeader(status)
+		}
+		w.Write([]byte("body"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runTransport runs fn with a client that reports through a Transport and
+// returns the session once all its stats are processed
+func runTransport(t *testing.T, transport func(*Transport), fn func(session *TestSession, client *http.Client)) *TestSession {
+	t.Helper()
+
+	runner := NewTestRunnerWithHandler(http.NewServeMux())
+	t.Cleanup(func() { runner.Close() })
+	session := runner.StartTest(t.Name())
+
+	tr := runner.Transport(nil)
+	if transport != nil {
+		transport(tr)
+	}
+	fn(session, &http.Client{Transport: tr})
+
+	runner.StopTest()
+	select {
+	case <-session.processed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session stats were not processed")
+	}
+	return session
+}
+
+// get sends a GET request and reads its response to the end
+func get(t *testing.T, ctx context.Context, client *http.Client, url string) {
+	t.Helper()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest() = %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s = %v", url, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func TestDefaultRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", "/"},
+		{"/", "/"},
+		{"/users/42/orders", "/users/:id/orders"},
+		{"/orders/0b6c7c52-5d37-4ff2-9c5e-1b2f3a4d5e6f", "/orders/:id"},
+		{"/blobs/deadbeefdeadbeef", "/blobs/:id"},
+		{"/cart/add", "/cart/add"},
+		{"/v2/items", "/v2/items"},
+		{"/files/report-2024", "/files/report-2024"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := &http.Request{URL: &url.URL{Path: tt.path}}
+			if got := DefaultRoute(req); got != tt.want {
+				t.Errorf("DefaultRoute(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransportLabels(t *testing.T) {
+	server := newTransportServer(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	tests := []struct {
+		name      string
+		overrides []map[string]string
+		want      map[string]string
+	}{
+		{"derived", nil, map[string]string{HostLabel: host, MethodLabel: http.MethodGet, RouteLabel: "/users/:id"}},
+		{"added and overridden", []map[string]string{{OperationLabel: "profile", RouteLabel: "/users/{id}"}},
+			map[string]string{HostLabel: host, MethodLabel: http.MethodGet, RouteLabel: "/users/{id}", OperationLabel: "profile"}},
+		{"removed", []map[string]string{{HostLabel: ""}}, map[string]string{MethodLabel: http.MethodGet, RouteLabel: "/users/:id"}},
+		{"nested", []map[string]string{{OperationLabel: "profile"}, {OperationLabel: "avatar"}},
+			map[string]string{HostLabel: host, MethodLabel: http.MethodGet, RouteLabel: "/users/:id", OperationLabel: "avatar"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := runTransport(t, nil, func(session *TestSession, client *http.Client) {
+				ctx := context.Background()
+				for _, labels := range tt.overrides {
+					ctx = WithLabels(ctx, labels)
+				}
+				get(t, ctx, client, server.URL+"/users/42")
+			})
+
+			stats := session.GetLabelStats()
+			if len(stats) != 1 {
+				t.Fatalf("got %d label sets, want 1", len(stats))
+			}
+			got := stats[0].Labels
+			if len(got) != len(tt.want) {
+				t.Errorf("reported labels %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("label %q = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTransportFailureStatus(t *testing.T) {
+	server := newTransportServer(t)
+
+	tests := []struct {
+		name       string
+		failure    func(code int) bool
+		status     int
+		wantFailed bool
+	}{
+		{"ok", nil, http.StatusOK, false},
+		{"redirect", nil, http.StatusNotModified, false},
+		{"not found", nil, http.StatusNotFound, true},
+		{"server error", nil, http.StatusInternalServerError, true},
+		{"custom accepting not found", func(code int) bool { return code >= 500 }, http.StatusNotFound, false},
+		{"custom rejecting redirect", func(code int) bool { return code >= 300 }, http.StatusNotModified, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := runTransport(t, func(tr *Transport) { tr.FailureStatus = tt.failure }, func(session *TestSession, client *http.Client) {
+				get(t, context.Background(), client, server.URL+"/?status="+strconv.Itoa(tt.status))
+			})
+
+			stats := session.GetLabelStats()
+			if len(stats) != 1 {
+				t.Fatalf("got %d label sets, want 1", len(stats))
+			}
+			if failed := stats[0].CumulativeErrorRate > 0; failed != tt.wantFailed {
+				t.Errorf("status %d failed = %v, want %v", tt.status, failed, tt.wantFailed)
+			}
+			if responses := stats[0].Responses; responses == nil || responses.StatusCodes[tt.status] != 1 {
+				t.Errorf("responses %+v, want one with status %d", responses, tt.status)
+			}
+		})
+	}
+}
+
+func TestTransportReportsBody(t *testing.T) {
+	server := newTransportServer(t)
+
+	tests := []struct {
+		name      string
+		read      func(body io.ReadCloser)
+		wantBytes int64
+	}{
+		{"read to the end", func(body io.ReadCloser) { io.Copy(io.Discard, body) }, int64(len("body"))},
+		{"closed unread", func(body io.ReadCloser) { body.Close() }, 0},
+		{"closed after a partial read", func(body io.ReadCloser) {
+			body.Read(make([]byte, 2))
+			body.Close()
+		}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reported int64
+			session := runTransport(t, nil, func(session *TestSession, client *http.Client) {
+				resp, err := client.Get(server.URL + "/")
+				if err != nil {
+					t.Fatalf("GET = %v", err)
+				}
+				tt.read(resp.Body)
+				// The round trip is reported before the body is closed
+				reported = session.dataCollector.GetTotalRequests()
+				resp.Body.Close()
+			})
+
+			if reported != 1 {
+				t.Errorf("%d round trips reported before Close, want 1", reported)
+			}
+			stats := session.GetLabelStats()
+			if len(stats) != 1 || stats[0].TotalRequests != 1 {
+				t.Fatalf("got label sets %+v, want one with one request", stats)
+			}
+			if responses := stats[0].Responses; responses == nil || responses.TotalBytes != tt.wantBytes {
+				t.Errorf("responses %+v, want %d bytes", responses, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestTransportInLoadDriver(t *testing.T) {
+	server := newTransportServer(t)
+
+	session := runTransport(t, nil, func(session *TestSession, client *http.Client) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// One call sends two requests; the call after it stops the driver
+		calls := 0
+		session.RunClosed(ctx, 1, func(ctx context.Context, vu *VU) error {
+			if calls++; calls > 1 {
+				cancel()
+				return nil
+			}
+			get(t, ctx, client, server.URL+"/fast")
+			get(t, ctx, client, server.URL+slowRoute)
+			return nil
+		})
+	})
+
+	// Each round trip is reported with its own labels and latency, apart
+	// from the driver's unlabeled report of the call
+	routes := make(map[string]*LabelStats)
+	for _, stats := range session.GetLabelStats() {
+		routes[stats.Labels[RouteLabel]] = stats
+	}
+	fast, slow := routes["/fast"], routes[slowRoute]
+	if fast == nil || slow == nil || fast.TotalRequests != 1 || slow.TotalRequests != 1 {
+		t.Fatalf("got routes %v, want /fast and %s with one request each", routes, slowRoute)
+	}
+	if fast.CumulativeAvgRT >= float64(slowDelay.Milliseconds()) {
+		t.Errorf("/fast took %vms, want less than %v", fast.CumulativeAvgRT, slowDelay)
+	}
+	if slow.CumulativeAvgRT < float64(slowDelay.Milliseconds()) {
+		t.Errorf("%s took %vms, want at least %v", slowRoute, slow.CumulativeAvgRT, slowDelay)
+	}
+	if got := session.cumulativeStats.totalRequests(); got != 3 {
+		t.Errorf("session counts %d requests, want the call and its two round trips", got)
+	}
+}
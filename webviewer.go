@@ -21,20 +21,31 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// TimeUnit is the unit response times are displayed in on the dashboard
+type TimeUnit string
+
+const (
+	UnitMicrosecond TimeUnit = "us"
+	UnitMillisecond TimeUnit = "ms"
+	UnitSecond      TimeUnit = "s"
+)
+
 // WebViewer handles web interface for TestRunner
 type WebViewer struct {
-	testRunner *TestRunner
-	srv        *http.Server
-	clients    map[*websocket.Conn]bool
-	mutex      sync.RWMutex
+	testRunner  *TestRunner
+	srv         *http.Server
+	clients     map[*websocket.Conn]bool
+	displayUnit TimeUnit
+	mutex       sync.RWMutex
 }
 
 // newWebViewer creates a WebViewer with its own server
 func newWebViewer(testRunner *TestRunner, addr string, ownServer bool) *WebViewer {
 	wv := &WebViewer{
-		testRunner: testRunner,
-		clients:    make(map[*websocket.Conn]bool),
-		mutex:      sync.RWMutex{},
+		testRunner:  testRunner,
+		clients:     make(map[*websocket.Conn]bool),
+		displayUnit: UnitMillisecond,
+		mutex:       sync.RWMutex{},
 	}
 
 	if ownServer {
@@ -47,9 +58,10 @@ func newWebViewer(testRunner *TestRunner, addr string, ownServer bool) *WebViewe
 // newWebViewerWithHandler creates a WebViewer with handler registration
 func newWebViewerWithHandler(testRunner *TestRunner, registrar HandlerRegistrar) *WebViewer {
 	wv := &WebViewer{
-		testRunner: testRunner,
-		clients:    make(map[*websocket.Conn]bool),
-		mutex:      sync.RWMutex{},
+		testRunner:  testRunner,
+		clients:     make(map[*websocket.Conn]bool),
+		displayUnit: UnitMillisecond,
+		mutex:       sync.RWMutex{},
 	}
 
 	wv.registerHandlers(registrar)
@@ -90,6 +102,9 @@ func (wv *WebViewer) registerHandlers(registrar HandlerRegistrar) {
 	registrar.HandleFunc("/ptest/ws", wv.handleWebSocket)
 	registrar.HandleFunc("/ptest/api/sessions", wv.handleSessions)
 	registrar.HandleFunc("/ptest/api/current", wv.handleCurrentSession)
+	registrar.HandleFunc("/ptest/api/labels", wv.handleLabels)
+	registrar.HandleFunc("/ptest/api/labels/data", wv.handleLabelData)
+	registrar.HandleFunc("/ptest/api/transactions", wv.handleTransactions)
 }
 
 // serveIndex serves the main HTML page
@@ -136,6 +151,20 @@ func (wv *WebViewer) serveStatic(w http.ResponseWriter, r *http.Request) {
 	w.Write(content)
 }
 
+// SetDisplayUnit sets the unit response times are displayed in
+func (wv *WebViewer) SetDisplayUnit(unit TimeUnit) {
+	wv.mutex.Lock()
+	defer wv.mutex.Unlock()
+	wv.displayUnit = unit
+}
+
+// getDisplayUnit returns the unit response times are displayed in
+func (wv *WebViewer) getDisplayUnit() TimeUnit {
+	wv.mutex.RLock()
+	defer wv.mutex.RUnlock()
+	return wv.displayUnit
+}
+
 // handleSessions returns all sessions
 func (wv *WebViewer) handleSessions(w http.ResponseWriter, r *http.Request) {
 	sessions := wv.testRunner.ListSessions()
@@ -163,6 +192,59 @@ func (wv *WebViewer) handleCurrentSession(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(stats)
 }
 
+// requestedSession returns the session named by the session_id query parameter,
+// or the current session if none is given
+func (wv *WebViewer) requestedSession(r *http.Request) *TestSession {
+	if sessionID := r.URL.Query().Get("session_id"); sessionID != "" {
+		return wv.testRunner.GetSession(sessionID)
+	}
+	return wv.testRunner.GetCurrentSession()
+}
+
+// handleLabels returns per-label statistics of a session
+func (wv *WebViewer) handleLabels(w http.ResponseWriter, r *http.Request) {
+	session := wv.requestedSession(r)
+	if session == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("null"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.GetLabelStats())
+}
+
+// handleLabelData returns chart data for one label set of a session
+func (wv *WebViewer) handleLabelData(w http.ResponseWriter, r *http.Request) {
+	session := wv.requestedSession(r)
+	if session == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key parameter", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.GetLabeledChartData(key))
+}
+
+// handleTransactions returns transaction statistics of a session
+func (wv *WebViewer) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	session := wv.requestedSession(r)
+	if session == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("null"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(session.GetTransactionStats())
+}
+
 // handleWebSocket handles WebSocket connections
 func (wv *WebViewer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -193,10 +275,9 @@ func (wv *WebViewer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		})
 
 		// Send current chart data
-		chartData := currentSession.GetOptimizedChartData()
 		wv.sendToClient(conn, WSMessage{
 			Type: MsgTypeOptimizedData,
-			Data: chartData,
+			Data: wv.optimizedPayload(currentSession),
 		})
 	}
 
@@ -257,24 +338,28 @@ func (wv *WebViewer) streamSessionData(session *TestSession) {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		if session.Status != StatusRunning {
+		if !session.running() {
 			break
 		}
 
-		// Send both chart data and session stats
-		chartData := session.GetOptimizedChartData()
-		sessionStats := session.GetStats()
-
 		wv.broadcast(WSMessage{
 			Type: MsgTypeOptimizedData,
-			Data: map[string]interface{}{
-				"chart_data":    chartData,
-				"session_stats": sessionStats,
-			},
+			Data: wv.optimizedPayload(session),
 		})
 	}
 }
 
+// optimizedPayload builds the chart data message payload for a session
+func (wv *WebViewer) optimizedPayload(session *TestSession) map[string]interface{} {
+	// Send both chart data and session stats
+	return map[string]interface{}{
+		"chart_data":         session.GetOptimizedChartData(),
+		"session_stats":      session.GetStats(),
+		"display_unit":       wv.getDisplayUnit(),
+		"transaction_charts": session.GetTransactionCharts(),
+	}
+}
+
 // Close gracefully shuts down the web viewer
 func (wv *WebViewer) Close() error {
 	// Close all WebSocket connections